@@ -0,0 +1,384 @@
+package cc
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// WriteWasm writes the module as a binary WebAssembly module.
+func WriteWasm(w io.Writer, m *WasmModule) (err error) {
+	defer func() {
+		var r interface{}
+		if r = recover(); r == nil {
+			return
+		}
+
+		var ok bool
+		if err, ok = r.(error); !ok {
+			panic(r)
+		}
+	}()
+
+	var out bytes.Buffer
+	out.Write([]byte{0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00})
+
+	// The signatures of functions follow the types of call_indirect.
+	types := append([]*WasmSig{}, m.Types...)
+	typeIndex := make(map[string]int)
+	for i, sig := range types {
+		typeIndex[sig.String()] = i
+	}
+	sigIndex := func(sig *WasmSig) int {
+		if idx, ok := typeIndex[sig.String()]; ok {
+			return idx
+		}
+		typeIndex[sig.String()] = len(types)
+		types = append(types, sig)
+		return len(types) - 1
+	}
+	var funcTypes []int
+	for _, imp := range m.Imports {
+		funcTypes = append(funcTypes, sigIndex(imp.Sig))
+	}
+	for _, f := range m.Funcs {
+		sig := &WasmSig{Results: f.Results}
+		for _, p := range f.Params {
+			sig.Params = append(sig.Params, p.Type)
+		}
+		funcTypes = append(funcTypes, sigIndex(sig))
+	}
+
+	var sec bytes.Buffer
+	writeULEB(&sec, uint64(len(types)))
+	for _, sig := range types {
+		sec.WriteByte(0x60)
+		writeValTypes(&sec, sig.Params)
+		writeValTypes(&sec, sig.Results)
+	}
+	writeSection(&out, 1, sec.Bytes())
+
+	if len(m.Imports) > 0 {
+		sec.Reset()
+		writeULEB(&sec, uint64(len(m.Imports)))
+		for i, imp := range m.Imports {
+			writeName(&sec, imp.Module)
+			writeName(&sec, imp.Name)
+			sec.WriteByte(0x00)
+			writeULEB(&sec, uint64(funcTypes[i]))
+		}
+		writeSection(&out, 2, sec.Bytes())
+	}
+
+	sec.Reset()
+	writeULEB(&sec, uint64(len(m.Funcs)))
+	for _, t := range funcTypes[len(m.Imports):] {
+		writeULEB(&sec, uint64(t))
+	}
+	writeSection(&out, 3, sec.Bytes())
+
+	sec.Reset()
+	sec.Write([]byte{0x01, 0x70, 0x00})
+	writeULEB(&sec, uint64(len(m.Table)+1))
+	writeSection(&out, 4, sec.Bytes())
+
+	if m.Memory != nil {
+		sec.Reset()
+		sec.Write([]byte{0x01, 0x00})
+		writeULEB(&sec, uint64(m.Memory.Pages))
+		writeSection(&out, 5, sec.Bytes())
+	}
+
+	sec.Reset()
+	writeULEB(&sec, uint64(len(m.Globals)))
+	for _, g := range m.Globals {
+		sec.WriteByte(valType(g.Type))
+		if g.Mutable {
+			sec.WriteByte(0x01)
+		} else {
+			sec.WriteByte(0x00)
+		}
+		writeConstExpr(&sec, g.Type, g.Init)
+	}
+	writeSection(&out, 6, sec.Bytes())
+
+	type export struct {
+		name  string
+		kind  byte
+		index int
+	}
+	var exports []export
+	for i, f := range m.Funcs {
+		if f.Export != "" {
+			exports = append(exports, export{f.Export, 0x00, len(m.Imports) + i})
+		}
+	}
+	if m.Memory != nil && m.Memory.Export != "" {
+		exports = append(exports, export{m.Memory.Export, 0x02, 0})
+	}
+	for i, g := range m.Globals {
+		if g.Export != "" {
+			exports = append(exports, export{g.Export, 0x03, i})
+		}
+	}
+	sec.Reset()
+	writeULEB(&sec, uint64(len(exports)))
+	for _, e := range exports {
+		writeName(&sec, e.name)
+		sec.WriteByte(e.kind)
+		writeULEB(&sec, uint64(e.index))
+	}
+	writeSection(&out, 7, sec.Bytes())
+
+	if len(m.Table) > 0 {
+		sec.Reset()
+		sec.Write([]byte{0x01, 0x00})
+		writeConstExpr(&sec, "i32", 1)
+		writeULEB(&sec, uint64(len(m.Table)))
+		for _, name := range m.Table {
+			writeULEB(&sec, uint64(funcIndex(m, name)))
+		}
+		writeSection(&out, 9, sec.Bytes())
+	}
+
+	sec.Reset()
+	writeULEB(&sec, uint64(len(m.Funcs)))
+	for _, f := range m.Funcs {
+		body := funcBody(m, f)
+		writeULEB(&sec, uint64(len(body)))
+		sec.Write(body)
+	}
+	writeSection(&out, 10, sec.Bytes())
+
+	sec.Reset()
+	writeULEB(&sec, uint64(len(m.Data)))
+	for _, d := range m.Data {
+		sec.WriteByte(0x00)
+		writeConstExpr(&sec, "i32", int64(d.Offset))
+		writeULEB(&sec, uint64(len(d.Bytes)))
+		sec.Write(d.Bytes)
+	}
+	writeSection(&out, 11, sec.Bytes())
+
+	_, err = w.Write(out.Bytes())
+	return err
+}
+
+func funcIndex(m *WasmModule, name string) int {
+	idx, ok := m.FuncIndex(name)
+	if !ok {
+		panic(fmt.Errorf("unknown function '%s'", name))
+	}
+	return idx
+}
+
+func funcBody(m *WasmModule, f *WasmFunc) []byte {
+	var w bytes.Buffer
+
+	// Locals are declared in runs of the same type.
+	var runs []*WasmLocal
+	counts := make(map[*WasmLocal]int)
+	for i, l := range f.Locals {
+		if i == 0 || l.Type != f.Locals[i-1].Type {
+			runs = append(runs, l)
+		}
+		counts[runs[len(runs)-1]]++
+	}
+	writeULEB(&w, uint64(len(runs)))
+	for _, r := range runs {
+		writeULEB(&w, uint64(counts[r]))
+		w.WriteByte(valType(r.Type))
+	}
+
+	locals := make(map[string]int)
+	for i, l := range append(append([]*WasmLocal{}, f.Params...), f.Locals...) {
+		if _, ok := locals[l.Name]; ok {
+			panic(fmt.Errorf("duplicate local '%s'", l.Name))
+		}
+		locals[l.Name] = i
+	}
+	var labels []string
+	for _, instr := range f.Body {
+		writeInstr(&w, m, instr, locals, labels)
+		switch instr.Op {
+		case "block", "loop", "if":
+			labels = append(labels, instr.Name)
+		case "end":
+			labels = labels[:len(labels)-1]
+		}
+	}
+	w.WriteByte(0x0b)
+	return w.Bytes()
+}
+
+// writeInstr encodes an instruction. Labels are the names of the enclosing
+// blocks, innermost last.
+func writeInstr(w *bytes.Buffer, m *WasmModule, instr *Instr, locals map[string]int, labels []string) {
+	op := instr.Op
+	if code, ok := memoryOpcodes[op]; ok {
+		w.WriteByte(code)
+		writeULEB(w, uint64(naturalAlign(op)))
+		writeULEB(w, uint64(instr.Val))
+		return
+	}
+	if code, ok := opcodes[op]; ok {
+		w.WriteByte(code)
+		return
+	}
+
+	switch op {
+	case "block", "loop", "if":
+		w.WriteByte(map[string]byte{"block": 0x02, "loop": 0x03, "if": 0x04}[op])
+		w.WriteByte(0x40)
+	case "br", "br_if":
+		w.WriteByte(map[string]byte{"br": 0x0c, "br_if": 0x0d}[op])
+		writeULEB(w, uint64(labelDepth(labels, instr.Name)))
+	case "call":
+		w.WriteByte(0x10)
+		writeULEB(w, uint64(funcIndex(m, instr.Name)))
+	case "call_indirect":
+		w.WriteByte(0x11)
+		writeULEB(w, uint64(instr.Val))
+		w.WriteByte(0x00)
+	case "local.get", "local.set", "local.tee":
+		idx, ok := locals[instr.Name]
+		if !ok {
+			panic(fmt.Errorf("unknown local '%s'", instr.Name))
+		}
+		w.WriteByte(map[string]byte{"local.get": 0x20, "local.set": 0x21, "local.tee": 0x22}[op])
+		writeULEB(w, uint64(idx))
+	case "global.get", "global.set":
+		idx, ok := m.GlobalIndex(instr.Name)
+		if !ok {
+			panic(fmt.Errorf("unknown global '%s'", instr.Name))
+		}
+		w.WriteByte(map[string]byte{"global.get": 0x23, "global.set": 0x24}[op])
+		writeULEB(w, uint64(idx))
+	case "i32.const":
+		w.WriteByte(0x41)
+		writeSLEB(w, int64(int32(instr.Val)))
+	case "i64.const":
+		w.WriteByte(0x42)
+		writeSLEB(w, instr.Val)
+	case "memory.copy":
+		w.Write([]byte{0xfc, 0x0a, 0x00, 0x00})
+	case "memory.fill":
+		w.Write([]byte{0xfc, 0x0b, 0x00})
+	default:
+		panic(fmt.Errorf("unsupported instruction '%s'", op))
+	}
+}
+
+// writeConstExpr encodes a constant expression.
+func writeConstExpr(w *bytes.Buffer, t string, v int64) {
+	writeInstr(w, nil, &Instr{Op: t + ".const", Val: v}, nil, nil)
+	w.WriteByte(0x0b)
+}
+
+func labelDepth(labels []string, name string) int {
+	for i := len(labels) - 1; i >= 0; i-- {
+		if labels[i] == name {
+			return len(labels) - 1 - i
+		}
+	}
+	panic(fmt.Errorf("unknown label '%s'", name))
+}
+
+var opcodes = map[string]byte{
+	"unreachable": 0x00, "nop": 0x01, "else": 0x05, "return": 0x0f, "drop": 0x1a, "select": 0x1b, "end": 0x0b,
+
+	"i32.eqz": 0x45, "i32.eq": 0x46, "i32.ne": 0x47, "i32.lt_s": 0x48, "i32.lt_u": 0x49,
+	"i32.gt_s": 0x4a, "i32.gt_u": 0x4b, "i32.le_s": 0x4c, "i32.le_u": 0x4d, "i32.ge_s": 0x4e, "i32.ge_u": 0x4f,
+	"i64.eqz": 0x50, "i64.eq": 0x51, "i64.ne": 0x52, "i64.lt_s": 0x53, "i64.lt_u": 0x54,
+	"i64.gt_s": 0x55, "i64.gt_u": 0x56, "i64.le_s": 0x57, "i64.le_u": 0x58, "i64.ge_s": 0x59, "i64.ge_u": 0x5a,
+
+	"i32.clz": 0x67, "i32.ctz": 0x68, "i32.popcnt": 0x69, "i32.add": 0x6a, "i32.sub": 0x6b,
+	"i32.mul": 0x6c, "i32.div_s": 0x6d, "i32.div_u": 0x6e, "i32.rem_s": 0x6f, "i32.rem_u": 0x70,
+	"i32.and": 0x71, "i32.or": 0x72, "i32.xor": 0x73, "i32.shl": 0x74, "i32.shr_s": 0x75,
+	"i32.shr_u": 0x76, "i32.rotl": 0x77, "i32.rotr": 0x78,
+	"i64.clz": 0x79, "i64.ctz": 0x7a, "i64.popcnt": 0x7b, "i64.add": 0x7c, "i64.sub": 0x7d,
+	"i64.mul": 0x7e, "i64.div_s": 0x7f, "i64.div_u": 0x80, "i64.rem_s": 0x81, "i64.rem_u": 0x82,
+	"i64.and": 0x83, "i64.or": 0x84, "i64.xor": 0x85, "i64.shl": 0x86, "i64.shr_s": 0x87,
+	"i64.shr_u": 0x88, "i64.rotl": 0x89, "i64.rotr": 0x8a,
+
+	"i32.wrap_i64": 0xa7, "i64.extend_i32_s": 0xac, "i64.extend_i32_u": 0xad,
+	"i32.extend8_s": 0xc0, "i32.extend16_s": 0xc1,
+	"i64.extend8_s": 0xc2, "i64.extend16_s": 0xc3, "i64.extend32_s": 0xc4,
+}
+
+var memoryOpcodes = map[string]byte{
+	"i32.load": 0x28, "i64.load": 0x29,
+	"i32.load8_s": 0x2c, "i32.load8_u": 0x2d, "i32.load16_s": 0x2e, "i32.load16_u": 0x2f,
+	"i64.load8_s": 0x30, "i64.load8_u": 0x31, "i64.load16_s": 0x32, "i64.load16_u": 0x33,
+	"i64.load32_s": 0x34, "i64.load32_u": 0x35,
+	"i32.store": 0x36, "i64.store": 0x37,
+	"i32.store8": 0x3a, "i32.store16": 0x3b, "i64.store8": 0x3c, "i64.store16": 0x3d, "i64.store32": 0x3e,
+}
+
+// naturalAlign returns the log2 of the access size of a load or store.
+func naturalAlign(op string) int {
+	switch {
+	case strings.Contains(op, "8"):
+		return 0
+	case strings.Contains(op, "16"):
+		return 1
+	case strings.Contains(op, "32_"), strings.Contains(op, "store32"), strings.HasPrefix(op, "i32."):
+		return 2
+	}
+	return 3
+}
+
+func valType(t string) byte {
+	switch t {
+	case "i32":
+		return 0x7f
+	case "i64":
+		return 0x7e
+	}
+	panic(fmt.Errorf("unsupported value type '%s'", t))
+}
+
+func writeValTypes(w *bytes.Buffer, types []string) {
+	writeULEB(w, uint64(len(types)))
+	for _, t := range types {
+		w.WriteByte(valType(t))
+	}
+}
+
+func writeSection(w *bytes.Buffer, id byte, content []byte) {
+	w.WriteByte(id)
+	writeULEB(w, uint64(len(content)))
+	w.Write(content)
+}
+
+func writeName(w *bytes.Buffer, name string) {
+	writeULEB(w, uint64(len(name)))
+	w.WriteString(name)
+}
+
+// writeULEB writes v in unsigned LEB128.
+func writeULEB(w *bytes.Buffer, v uint64) {
+	for {
+		b := byte(v & 0x7f)
+		v >>= 7
+		if v == 0 {
+			w.WriteByte(b)
+			return
+		}
+		w.WriteByte(b | 0x80)
+	}
+}
+
+// writeSLEB writes v in signed LEB128.
+func writeSLEB(w *bytes.Buffer, v int64) {
+	for {
+		b := byte(v & 0x7f)
+		v >>= 7
+		if v == 0 && b&0x40 == 0 || v == -1 && b&0x40 != 0 {
+			w.WriteByte(b)
+			return
+		}
+		w.WriteByte(b | 0x80)
+	}
+}
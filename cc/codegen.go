@@ -2,31 +2,51 @@ package cc
 
 import "C"
 import (
-	"errors"
 	"fmt"
-	"io"
-	"strings"
+	"sort"
 )
 
 const StackSize = 65536
 
 type Codegen struct {
-	objects     []*Object
-	depth       int
-	blockCount  int
-	indentLevel int
-	writer      io.Writer
-}
+	objects []*Object
+	opts    *Options
+	module  *WasmModule
+	funcs   []*IRFunc
+	fn      *WasmFunc       // function being generated
+	exports map[string]bool // names exported so far
+
+	// Functions whose address is taken, in table order. Slot 0 of the
+	// table is left empty so that a null function pointer traps.
+	tableIndex map[string]int
 
-func (c *Codegen) Printf(format string, a ...interface{}) {
-	_, _ = fmt.Fprintf(c.writer, strings.Repeat("  ", c.indentLevel)+format, a...)
+	// Signatures used by call_indirect, in type order.
+	typeIndex map[string]int
+
+	// Control flow of the IR function being generated.
+	ir          *IRFunc
+	hasFrame    bool // whether the function pushes a frame
+	rpo         []*IRBlock
+	rpoIndex    map[*IRBlock]int
+	isLoop      map[*IRBlock]bool // targets of back edges
+	isMerge     map[*IRBlock]bool // targets of several forward edges
+	domChildren map[*IRBlock][]*IRBlock
 }
 
-func NewCodegen(w io.Writer, objects []*Object) *Codegen {
-	return &Codegen{writer: w, objects: objects}
+func NewCodegen(objects []*Object, funcs []*IRFunc, opts *Options) *Codegen {
+	return &Codegen{
+		objects:    objects,
+		funcs:      funcs,
+		opts:       opts,
+		module:     &WasmModule{},
+		exports:    map[string]bool{"memory": true},
+		tableIndex: make(map[string]int),
+		typeIndex:  make(map[string]int),
+	}
 }
 
-func (c *Codegen) Gen() (err error) {
+// Gen generates the wasm module of the program.
+func (c *Codegen) Gen() (m *WasmModule, err error) {
 	defer func() {
 		var r interface{}
 		if r = recover(); r == nil {
@@ -38,268 +58,557 @@ func (c *Codegen) Gen() (err error) {
 			panic(r)
 		}
 	}()
-	c.Printf("(module\n")
-	c.Indent(true)
+	c.GenImports()
 	c.GenData()
 	c.GenCode()
 
 	// TODO: It ought to be enough to everyone.
-	c.Printf("(memory $memory (export \"memory\") 2)\n")
-	c.Printf("(global $sp (mut i32) (i32.const %d))\n", StackSize)
-	c.Printf("(global $bp i32       (i32.const %d))\n", StackSize)
+	c.module.Memory = &WasmMemory{Pages: 2, Export: "memory"}
+	c.module.Globals = append([]*WasmGlobal{
+		{Name: "sp", Export: c.ExportName(StackPointerExport, "", false, true), Type: "i32", Mutable: true, Init: StackSize},
+		{Name: "bp", Type: "i32", Init: StackSize},
+	}, c.module.Globals...)
+	c.GenGlobalExports()
+	return c.module, nil
+}
+
+// Emit appends an instruction without immediates to the current function.
+func (c *Codegen) Emit(op string) {
+	c.fn.Body = append(c.fn.Body, &Instr{Op: op})
+}
+
+// EmitName appends an instruction referring to a label, local, global or
+// function.
+func (c *Codegen) EmitName(op string, name string) {
+	c.fn.Body = append(c.fn.Body, &Instr{Op: op, Name: name})
+}
+
+// Const appends a constant of wasm type t.
+func (c *Codegen) Const(t string, v int64) {
+	c.fn.Body = append(c.fn.Body, &Instr{Op: t + ".const", Val: v})
+}
+
+// GenImports imports the functions which are called or whose address is
+// taken, but which are not defined. Their module and name default to "env"
+// and the name of the function.
+func (c *Codegen) GenImports() {
+	defined := make(map[string]bool)
+	referenced := make(map[string]bool)
+	for _, o := range c.objects {
+		if o.Kind != OKFunction {
+			continue
+		}
+		defined[o.Name] = defined[o.Name] || !o.Function.IsPrototype
+		referenced[o.Name] = referenced[o.Name] || o.Function.IsReferenced
+	}
 
-	c.Indent(false)
-	c.Printf(")\n")
-	return
+	imports := make(map[string]*WasmImport)
+	for _, o := range c.objects {
+		if o.Kind != OKFunction || defined[o.Name] || !referenced[o.Name] {
+			continue
+		}
+		if o.IsStatic {
+			panic(fmt.Errorf("static function '%s' used but never defined", o.Name))
+		}
+		imp, ok := imports[o.Name]
+		if !ok {
+			imp = &WasmImport{Module: "env", Name: o.Name, Func: o.Name, Sig: &WasmSig{}}
+			for _, param := range o.Function.Params {
+				imp.Sig.Params = append(imp.Sig.Params, param.Type.WasmType())
+			}
+			if o.Type.Val.(*FuncVal).NoPrototype {
+				imp.Sig.Params = c.CallParams(o.Name)
+			}
+			imp.Sig.Results = []string{o.Type.Base.WasmType()}
+			imports[o.Name] = imp
+			c.module.Imports = append(c.module.Imports, imp)
+		}
+		if o.Function.ImportModule != "" {
+			imp.Module = o.Function.ImportModule
+		}
+		if o.Function.ImportName != "" {
+			imp.Name = o.Function.ImportName
+		}
+	}
+}
+
+// CallParams returns the parameter types of a function declared without a
+// prototype, which are those of its promoted arguments, as for a function
+// declared implicitly. All calls must pass the same types.
+func (c *Codegen) CallParams(name string) []string {
+	var params []string
+	seen := false
+	for _, f := range c.funcs {
+		for _, b := range f.Blocks {
+			for _, instr := range b.Instrs {
+				if instr.Op != IRCall || instr.Name != name {
+					continue
+				}
+				var types []string
+				for _, a := range instr.Args {
+					types = append(types, a.Type)
+				}
+				if seen && fmt.Sprint(types) != fmt.Sprint(params) {
+					panic(fmt.Errorf("conflicting argument types in calls to '%s'", name))
+				}
+				params, seen = types, true
+			}
+		}
+	}
+	return params
+}
+
+// ExportName returns the name the named function or global is exported
+// under, or an empty string if it is not exported. An export name given by
+// attribute is always exported. Otherwise it is exported if listed in the
+// options, or by default if there is no list, or if all are exported.
+func (c *Codegen) ExportName(name string, exportName string, byDefault bool, byExportAll bool) string {
+	export := exportName != ""
+	switch {
+	case export:
+		name = exportName
+	case c.opts.ExportAll:
+		export = byExportAll
+	case len(c.opts.Exports) > 0:
+		for _, e := range c.opts.Exports {
+			export = export || e == name
+		}
+	default:
+		export = byDefault
+	}
+	if !export {
+		return ""
+	}
+
+	if c.exports[name] {
+		panic(fmt.Errorf("duplicate export '%s'", name))
+	}
+	c.exports[name] = true
+	return name
+}
+
+// GenGlobalExports exports the addresses of the globals to export as
+// immutable wasm globals.
+func (c *Codegen) GenGlobalExports() {
+	for _, o := range c.objects {
+		if o.Kind != OKGlobal || o.Name == "" || o.Global.IsLocal {
+			continue
+		}
+		if export := c.ExportName(o.Name, o.Export, false, !o.IsStatic); export != "" {
+			c.module.Globals = append(c.module.Globals, &WasmGlobal{Export: export, Type: "i32", Init: int64(o.Global.Offset)})
+		}
+	}
 }
 
 func (c *Codegen) GenData() int {
 	memoryOffset := 0
 	for _, o := range c.objects {
 		if o.Kind == OKGlobal {
-			c.Printf("(data (i32.const %d) \"%s\")\n", memoryOffset, strings.Repeat("\\00", o.Type.Size))
+			memoryOffset = alignTo(memoryOffset, o.Alignment())
 			o.Global.Offset = memoryOffset
 			memoryOffset += o.Type.Size
 		} else if o.Kind == OKStringLiteral {
-			c.Printf("(data (i32.const %d) \"%s\\00\")\n", memoryOffset, o.Global.Val.([]byte))
 			o.Global.Offset = memoryOffset
 			memoryOffset += len(o.Global.Val.([]byte)) + 1
 		}
 	}
 
+	// Initial values are emitted once the addresses they refer to are known.
+	for _, o := range c.objects {
+		if o.Kind == OKGlobal {
+			c.module.Data = append(c.module.Data, &WasmData{Offset: o.Global.Offset, Bytes: c.GlobalData(o)})
+		} else if o.Kind == OKStringLiteral {
+			bs := append(append([]byte{}, o.Global.Val.([]byte)...), 0)
+			c.module.Data = append(c.module.Data, &WasmData{Offset: o.Global.Offset, Bytes: bs})
+		}
+	}
+
 	return memoryOffset
 }
 
+// GlobalData returns the initial value of a global with its relocations
+// applied.
+func (c *Codegen) GlobalData(o *Object) []byte {
+	data := make([]byte, o.Type.Size)
+	if o.Global.Val != nil {
+		copy(data, o.Global.Val.([]byte))
+	}
+	for _, r := range o.Global.Relocs {
+		addr := r.Addend
+		if r.Label.Kind == OKFunction {
+			addr += c.TableIndex(r.Label.Name)
+		} else {
+			addr += r.Label.Global.Offset
+		}
+		writeInt(data[r.Offset:], addr, 4)
+	}
+	return data
+}
+
 func (c *Codegen) GenCode() {
-	for _, o := range c.objects {
-		if o.Kind != OKFunction || o.Function.IsDefinition {
-			continue
+	for _, f := range c.funcs {
+		o := f.Object
+		c.fn = &WasmFunc{
+			Name:    f.Name,
+			Export:  c.ExportName(o.Name, o.Export, !o.IsStatic, true),
+			Results: []string{f.Result},
+		}
+		isParam := make(map[*IRReg]bool)
+		for i, param := range o.Function.Params {
+			c.fn.Params = append(c.fn.Params, &WasmLocal{Name: param.Name, Type: f.Params[i].Type})
+			isParam[f.Params[i]] = true
 		}
+		for _, r := range f.Regs {
+			if !isParam[r] {
+				c.fn.Locals = append(c.fn.Locals, &WasmLocal{Name: c.RegName(f, r), Type: r.Type})
+			}
+		}
+		c.module.Funcs = append(c.module.Funcs, c.fn)
 
-		funcHeader := fmt.Sprintf("(func $%s (export \"%s\")", o.Name, o.Name)
-		for _, param := range o.Function.Params {
-			funcHeader += fmt.Sprintf(" (param $%s %s)", param.Name, param.Type.WasmType())
-		}
-		funcHeader += fmt.Sprintf(" (result i32)\n")
-		c.Printf(funcHeader)
-		c.Indent(true)
-		c.Printf("(local $result i32)\n")
-
-		// Prologue
-		c.Printf("global.get $sp\n")
-		c.Printf("i32.const %d\n", o.Function.StackSize)
-		c.Printf("i32.sub\n")
-		c.Printf("global.set $sp\n")
-		for _, param := range o.Function.Params {
-			c.Printf("global.get $sp\n")
-			c.Printf("i32.const %d\n", param.Local.Offset)
-			c.Printf("i32.add\n")
-			c.Printf("local.get $%s\n", param.Name)
-			c.Printf("%s.store\n", param.Type.WasmType())
-		}
-		c.Printf("block $ENTRY\n")
-		c.Indent(true)
-
-		c.GenStmt(o.Function.Body)
-
-		// Epilogue
-		c.Indent(false)
-		c.Printf("end\n")
-		c.Printf("global.get $sp\n")
-		c.Printf("i32.const %d\n", o.Function.StackSize)
-		c.Printf("i32.add\n")
-		c.Printf("global.set $sp\n")
-		c.Printf("local.get $result\n")
-		c.Printf("return\n")
-
-		c.Indent(false)
-		c.Printf(")\n")
+		// Prologue. Locals are addressed relative to $r.fp since $sp moves
+		// when variable length arrays are allocated. A function which has
+		// no frame and leaves $sp alone needs neither. A frame aligned
+		// more than $sp is rounded down, and $sp is saved in $r.sp to be
+		// restored on return.
+		c.hasFrame = f.FrameSize > 0
+		for _, b := range f.Blocks {
+			for _, instr := range b.Instrs {
+				c.hasFrame = c.hasFrame || instr.Op == IRSetSP || instr.Op == IRFrame
+			}
+		}
+		if c.hasFrame {
+			c.fn.Locals = append(c.fn.Locals, &WasmLocal{Name: "r.fp", Type: "i32"})
+			c.EmitName("global.get", "sp")
+			if f.FrameAlign > StackAlign {
+				c.fn.Locals = append(c.fn.Locals, &WasmLocal{Name: "r.sp", Type: "i32"})
+				c.EmitName("local.tee", "r.sp")
+			}
+			c.Const("i32", int64(f.FrameSize))
+			c.Emit("i32.sub")
+			if f.FrameAlign > StackAlign {
+				c.Const("i32", int64(-f.FrameAlign))
+				c.Emit("i32.and")
+			}
+			c.EmitName("local.tee", "r.fp")
+			c.EmitName("global.set", "sp")
+		}
+		c.GenFunc(f)
 	}
 }
 
-func (c *Codegen) GenStmt(node *Node) {
-	switch node.Kind {
-	case NKIf:
-		blockFalseName := c.NextBlockName()
-		blockTrueName := c.NextBlockName()
-		c.Printf("block %s\n", blockFalseName)
-		c.Indent(true)
-		c.Printf("block %s\n", blockTrueName)
-		c.Indent(true)
-		c.GenExpr(node.IfClause.Cond)
-		c.Printf("i32.eqz\n")
-		c.Printf("br_if %s\n", blockTrueName)
-		c.GenStmt(node.IfClause.Then)
-		c.Printf("br %s\n", blockFalseName)
-		c.Indent(false)
-		c.Printf("end\n")
-		if node.IfClause.Else != nil {
-			c.GenStmt(node.IfClause.Else)
-		}
-		c.Printf("br %s\n", blockFalseName)
-		c.Indent(false)
-		c.Printf("end\n")
-		return
-	case NKFor:
-		blockName := c.NextBlockName()
-		loopName := c.NextBlockName()
-		c.Printf("block %s\n", blockName)
-		c.Indent(true)
-		if node.ForClause.Init != nil {
-			c.GenStmt(node.ForClause.Init)
-		}
-		c.Printf("loop %s\n", loopName)
-		c.Indent(true)
-		if node.ForClause.Cond != nil {
-			c.GenExpr(node.ForClause.Cond)
-			c.Printf("i32.eqz\n")
-			c.Printf("br_if %s\n", blockName)
-		}
-
-		c.GenStmt(node.ForClause.Body)
-		if node.ForClause.Increment != nil {
-			c.GenExpr(node.ForClause.Increment)
-		}
-		c.Printf("br %s\n", loopName)
-		c.Indent(false)
-		c.Printf("end\n")
-		c.Printf("br %s\n", blockName)
-		c.Indent(false)
-		c.Printf("end\n")
-		return
-	case NKBlock, NKStmtsExpr:
-		for _, n := range node.Block.Stmts {
-			c.GenStmt(n)
+// RegName returns the wasm local holding a register. Parameters keep their
+// C name, other registers are named "r.N", and the frame and stack pointers
+// "r.fp" and "r.sp", which can't clash with it.
+func (c *Codegen) RegName(f *IRFunc, r *IRReg) string {
+	for i, p := range f.Params {
+		if p == r {
+			return f.Object.Function.Params[i].Name
 		}
-		return
-	case NKReturn:
-		c.GenExpr(node.Unary.Expr)
+	}
+	return fmt.Sprintf("r.%d", r.ID)
+}
 
-		c.Printf("local.set $result\n")
-		c.Printf("br $ENTRY\n")
-		return
-	case NKExprStmt:
-		c.GenExpr(node.Unary.Expr)
-		return
+// GenFunc emits the body of a function, turning its control flow graph into
+// nested blocks and loops. This is the algorithm of Norman Ramsey, "Beyond
+// Relooper": every block is emitted once, within the blocks it dominates.
+// Forward edges to a block with several predecessors branch out of a
+// "block" ending right before it, and back edges branch to the start of a
+// "loop" around it.
+func (c *Codegen) GenFunc(f *IRFunc) {
+	c.ir = f
+	c.rpo = f.ReversePostorder()
+	c.rpoIndex = make(map[*IRBlock]int)
+	for i, b := range c.rpo {
+		c.rpoIndex[b] = i
+	}
+	preds := Preds(c.rpo)
+	idom := Dominators(c.rpo, preds)
+
+	c.isLoop = make(map[*IRBlock]bool)
+	c.isMerge = make(map[*IRBlock]bool)
+	c.domChildren = make(map[*IRBlock][]*IRBlock)
+	for _, b := range c.rpo {
+		forward := 0
+		for _, p := range preds[b] {
+			if c.rpoIndex[p] >= c.rpoIndex[b] {
+				c.isLoop[b] = true
+			} else {
+				forward++
+			}
+		}
+		c.isMerge[b] = forward > 1
+		if b != c.rpo[0] {
+			c.domChildren[idom[b]] = append(c.domChildren[idom[b]], b)
+		}
 	}
 
-	panic(errors.New("invalid statement"))
+	c.GenTree(c.rpo[0])
+	c.fn.Body = removeFallthroughBr(c.fn.Body)
+	// Every path has returned, but the validator can't tell unless the body
+	// ends with a return.
+	if c.fn.Body[len(c.fn.Body)-1].Op != "return" {
+		c.Emit("unreachable")
+	}
 }
 
-func (c *Codegen) GenExpr(node *Node) {
-	switch node.Kind {
-	case NKNum:
-		c.Printf("%s.const %d\n", node.Type.WasmType(), node.Num.Val)
-		return
-	case NKNeg:
-		c.Printf("%s.const 0\n", node.Type.WasmType())
-		c.GenExpr(node.Unary.Expr)
-		c.Printf("%s.sub\n", node.Type.WasmType())
-		return
-	case NKVariable:
-		c.GenAddr(node)
-		c.Printf("%s.%s\n", node.Type.WasmType(), node.Type.WasmLoad())
-		return
-	case NKStringLiteral:
-		c.GenAddr(node)
-		return
-	case NKDeRef:
-		c.GenAddr(node.Unary.Expr)
-		c.Printf("%s.%s\n", node.Type.WasmType(), node.Type.WasmLoad())
-		return
-	case NKAddr:
-		c.GenAddr(node.Unary.Expr)
-		return
-	case NKAssign:
-		c.GenAddr(node.Binary.Lhs)
-		c.GenExpr(node.Binary.Rhs)
-		c.Printf("%s.store\n", node.Type.WasmType())
-		c.GenAddr(node.Binary.Lhs)
-		return
-	case NKComma:
-		c.GenExpr(node.Binary.Lhs)
-		c.GenAddr(node.Binary.Rhs)
-		return
-	case NKStmtsExpr:
-		c.GenStmt(node)
+// removeFallthroughBr removes the br to the end of a block right before
+// that end.
+func removeFallthroughBr(body []*Instr) []*Instr {
+	var labels []*Instr
+	result := body[:0]
+	for i, instr := range body {
+		switch instr.Op {
+		case "block", "loop", "if":
+			labels = append(labels, instr)
+		case "end":
+			labels = labels[:len(labels)-1]
+		case "br":
+			next := i + 1
+			if next < len(body) && body[next].Op == "end" {
+				label := labels[len(labels)-1]
+				if label.Op == "block" && label.Name == instr.Name {
+					continue
+				}
+			}
+		}
+		result = append(result, instr)
+	}
+	return result
+}
+
+// GenTree emits the block b followed by the merge blocks it dominates.
+func (c *Codegen) GenTree(b *IRBlock) {
+	var merges []*IRBlock
+	for _, child := range c.domChildren[b] {
+		if c.isMerge[child] {
+			merges = append(merges, child)
+		}
+	}
+	// The last merge block is the outermost one.
+	sort.Slice(merges, func(i, j int) bool { return c.rpoIndex[merges[i]] > c.rpoIndex[merges[j]] })
+
+	if c.isLoop[b] {
+		c.EmitName("loop", c.LoopName(b))
+		c.GenWithin(b, merges)
+		c.Emit("end")
 		return
-	case NKFuncCall:
-		for _, arg := range node.FuncCall.Args {
-			c.GenExpr(arg)
+	}
+	c.GenWithin(b, merges)
+}
+
+func (c *Codegen) GenWithin(b *IRBlock, merges []*IRBlock) {
+	if len(merges) == 0 {
+		for _, instr := range b.Instrs {
+			c.GenInstr(b, instr)
 		}
-		c.Printf("call $%s\n", node.FuncCall.Name)
 		return
 	}
+	c.EmitName("block", c.BlockName(merges[0]))
+	c.GenWithin(b, merges[1:])
+	c.Emit("end")
+	c.GenTree(merges[0])
+}
 
-	c.GenExpr(node.Binary.Lhs)
-	c.GenExpr(node.Binary.Rhs)
+// GenBranch emits the jump from one block to another.
+func (c *Codegen) GenBranch(from *IRBlock, to *IRBlock) {
+	switch {
+	case c.rpoIndex[to] <= c.rpoIndex[from]:
+		c.EmitName("br", c.LoopName(to))
+	case c.isMerge[to]:
+		c.EmitName("br", c.BlockName(to))
+	default:
+		c.GenTree(to)
+	}
+}
 
-	switch node.Kind {
-	case NKAdd:
-		c.Printf("%s.add\n", node.Type.WasmType())
-		return
-	case NKSub:
-		c.Printf("%s.sub\n", node.Type.WasmType())
+// IsBr reports whether the jump from one block to another is a single br.
+func (c *Codegen) IsBr(from *IRBlock, to *IRBlock) bool {
+	return c.rpoIndex[to] <= c.rpoIndex[from] || c.isMerge[to]
+}
+
+func (c *Codegen) BlockName(b *IRBlock) string {
+	return fmt.Sprintf("B%d", b.ID)
+}
+
+func (c *Codegen) LoopName(b *IRBlock) string {
+	return fmt.Sprintf("L%d", b.ID)
+}
+
+// Get pushes the value of a register.
+func (c *Codegen) Get(r *IRReg) {
+	c.EmitName("local.get", c.RegName(c.ir, r))
+}
+
+func (c *Codegen) Set(r *IRReg) {
+	c.EmitName("local.set", c.RegName(c.ir, r))
+}
+
+var irWasmOps = map[IROp]string{
+	IRAdd: "add", IRSub: "sub", IRMul: "mul", IRDiv: "div_s", IRAnd: "and", IROr: "or",
+	IRShl: "shl", IRShr: "shr_s", IRShrU: "shr_u",
+	IREq: "eq", IRNe: "ne", IRLt: "lt_s", IRLe: "le_s", IREqz: "eqz",
+}
+
+// GenInstr emits an instruction of the block, storing its result if any.
+func (c *Codegen) GenInstr(block *IRBlock, instr *IRInstr) {
+	switch instr.Op {
+	case IRConst:
+		c.Const(instr.Dst.Type, instr.Imm)
+	case IRFrame:
+		c.EmitName("local.get", "r.fp")
+		c.Const("i32", int64(instr.Object.Local.Offset))
+		c.Emit("i32.add")
+	case IRGlobal:
+		c.Const("i32", int64(instr.Object.Global.Offset))
+	case IRFuncAddr:
+		c.Const("i32", int64(c.TableIndex(instr.Name)))
+	case IRGetSP:
+		c.EmitName("global.get", "sp")
+	case IRSetSP:
+		c.Get(instr.Args[0])
+		c.EmitName("global.set", "sp")
 		return
-	case NKMul:
-		c.Printf("%s.mul\n", node.Type.WasmType())
+	case IRCopy:
+		c.Get(instr.Args[0])
+	case IRExtend:
+		c.Get(instr.Args[0])
+		c.Emit("i64.extend_i32_s")
+	case IRWrap:
+		c.Get(instr.Args[0])
+		c.Emit("i32.wrap_i64")
+	case IRExtend8:
+		c.Get(instr.Args[0])
+		c.Emit("i32.extend8_s")
+	case IRExtend16:
+		c.Get(instr.Args[0])
+		c.Emit("i32.extend16_s")
+	case IRLoad:
+		c.Get(instr.Args[0])
+		c.Emit(instr.Dst.Type + "." + wasmLoad(instr.Size, instr.Dst.Type, instr.Unsigned))
+	case IRStore:
+		c.Get(instr.Args[0])
+		c.Get(instr.Args[1])
+		c.Emit(instr.Args[1].Type + "." + wasmStore(instr.Size, instr.Args[1].Type))
 		return
-	case NKDiv:
-		c.Printf("%s.div_s\n", node.Type.WasmType())
+	case IRCall, IRCallIndirect:
+		for _, a := range instr.Args {
+			c.Get(a)
+		}
+		if instr.Op == IRCall {
+			c.EmitName("call", instr.Name)
+			break
+		}
+		var params []string
+		for _, a := range instr.Args[:len(instr.Args)-1] {
+			params = append(params, a.Type)
+		}
+		c.fn.Body = append(c.fn.Body, &Instr{Op: "call_indirect", Val: int64(c.FuncType(params, instr.Dst.Type))})
+	case IRMemCopy:
+		c.Get(instr.Args[0])
+		c.Get(instr.Args[1])
+		c.Const("i32", instr.Imm)
+		c.Emit("memory.copy")
 		return
-	case NKEq:
-		c.Printf("%s.eq\n", node.Type.WasmType())
+	case IRMemFill:
+		c.Get(instr.Args[0])
+		c.Const("i32", 0)
+		c.Const("i32", instr.Imm)
+		c.Emit("memory.fill")
 		return
-	case NKNe:
-		c.Printf("%s.ne\n", node.Type.WasmType())
+	case IRJump:
+		c.GenBranch(block, instr.Targets[0])
 		return
-	case NKLt:
-		c.Printf("%s.lt_s\n", node.Type.WasmType())
+	case IRBranch:
+		then, els := instr.Targets[0], instr.Targets[1]
+		c.Get(instr.Args[0])
+		switch {
+		case c.IsBr(block, then):
+			c.EmitName("br_if", c.BranchName(block, then))
+			c.GenBranch(block, els)
+		case c.IsBr(block, els):
+			c.Emit("i32.eqz")
+			c.EmitName("br_if", c.BranchName(block, els))
+			c.GenBranch(block, then)
+		default:
+			c.Emit("if")
+			c.GenBranch(block, then)
+			c.Emit("else")
+			c.GenBranch(block, els)
+			c.Emit("end")
+		}
 		return
-	case NKLe:
-		c.Printf("%s.le_s\n", node.Type.WasmType())
+	case IRReturn:
+		switch {
+		case c.hasFrame && c.ir.FrameAlign > StackAlign:
+			c.EmitName("local.get", "r.sp")
+			c.EmitName("global.set", "sp")
+		case c.hasFrame:
+			c.EmitName("local.get", "r.fp")
+			c.Const("i32", int64(c.ir.FrameSize))
+			c.Emit("i32.add")
+			c.EmitName("global.set", "sp")
+		}
+		c.Get(instr.Args[0])
+		c.Emit("return")
 		return
+	default:
+		op, ok := irWasmOps[instr.Op]
+		if !ok {
+			panic(fmt.Errorf("unknown IR instruction '%s'", instr.Op))
+		}
+		for _, a := range instr.Args {
+			c.Get(a)
+		}
+		c.Emit(instr.Args[0].Type + "." + op)
 	}
+	c.Set(instr.Dst)
+}
 
-	panic(errors.New("invalid expression"))
+// BranchName returns the label a br from one block to another refers to.
+func (c *Codegen) BranchName(from *IRBlock, to *IRBlock) string {
+	if c.rpoIndex[to] <= c.rpoIndex[from] {
+		return c.LoopName(to)
+	}
+	return c.BlockName(to)
 }
 
-func (c *Codegen) GenAddr(node *Node) {
-	switch node.Kind {
-	case NKVariable, NKStringLiteral:
-		switch node.Variable.Object.Kind {
-		case OKLocal:
-			c.Printf("global.get $sp\n")
-			c.Printf("i32.const %d\n", node.Variable.Object.Local.Offset)
-			c.Printf("i32.add\n")
-		case OKGlobal, OKStringLiteral:
-			c.Printf("i32.const %d\n", node.Variable.Object.Global.Offset)
-		default:
-			panic(errors.New("not a lvalue"))
-		}
-		return
-	case NKDeRef:
-		c.GenExpr(node.Unary.Expr)
-		return
-	case NKComma:
-		c.GenExpr(node.Binary.Lhs)
-		c.GenAddr(node.Binary.Rhs)
-		return
+// wasmLoad returns the load instruction, without the type, reading size
+// bytes into a value of wasm type t.
+func wasmLoad(size int, t string, unsigned bool) string {
+	if size == wasmSize(t) {
+		return "load"
 	}
+	if unsigned {
+		return fmt.Sprintf("load%d_u", size*8)
+	}
+	return fmt.Sprintf("load%d_s", size*8)
+}
 
-	panic(errors.New("not a lvalue"))
+func wasmStore(size int, t string) string {
+	if size == wasmSize(t) {
+		return "store"
+	}
+	return fmt.Sprintf("store%d", size*8)
 }
 
-func (c *Codegen) NextBlockName() string {
-	result := fmt.Sprintf("$B%d", c.blockCount)
-	c.blockCount++
-	return result
+// TableIndex returns the slot of the named function in the indirect
+// function table, which is also the value of a pointer to it.
+func (c *Codegen) TableIndex(name string) int {
+	if idx, ok := c.tableIndex[name]; ok {
+		return idx
+	}
+	c.module.Table = append(c.module.Table, name)
+	c.tableIndex[name] = len(c.module.Table)
+	return len(c.module.Table)
 }
 
-func (c *Codegen) Indent(right bool) {
-	if right {
-		c.indentLevel++
-	} else {
-		c.indentLevel--
+// FuncType returns the index of the type used to call_indirect a function
+// with the given wasm parameter and result types.
+func (c *Codegen) FuncType(params []string, result string) int {
+	sig := &WasmSig{Params: params, Results: []string{result}}
+	if _, ok := c.typeIndex[sig.String()]; !ok {
+		c.typeIndex[sig.String()] = len(c.module.Types)
+		c.module.Types = append(c.module.Types, sig)
 	}
+	return c.typeIndex[sig.String()]
 }
@@ -4,7 +4,57 @@ import (
 	"io"
 )
 
+// Format is the output format of the compiler.
+type Format int
+
+const (
+	FormatWat  Format = iota // WebAssembly text
+	FormatWasm               // binary WebAssembly module
+	FormatIR                 // textual dump of the intermediate representation
+)
+
+// StackPointerExport is the name under which the shadow stack pointer is
+// exported when listed in Options.Exports.
+const StackPointerExport = "__stack_pointer"
+
+// Options controls the output of the compiler and what it exports.
+type Options struct {
+	Format Format
+
+	// Exports lists the functions and globals to export, which may include
+	// StackPointerExport. When empty, every function which is not static is
+	// exported.
+	Exports []string
+
+	// ExportAll exports every function, static or not, every global which is
+	// not static, and the stack pointer.
+	ExportAll bool
+
+	// OptLevel is the optimization level, 0 leaves the IR as lowered from the
+	// AST apart from keeping locals in registers. OptSize makes optimizations
+	// favor smaller code.
+	OptLevel int
+	OptSize  bool
+
+	// Passes to run or not regardless of the optimization level, by name.
+	EnablePasses  []string
+	DisablePasses []string
+
+	// PrintAfter lists the passes after which the IR is written to PrintTo,
+	// or os.Stderr, or the WebAssembly text if PrintWat. "all" lists them
+	// all.
+	PrintAfter []string
+	PrintTo    io.Writer
+	PrintWat   bool
+}
+
+// Compile compiles the C program s to WebAssembly text with the default
+// options.
 func Compile(w io.Writer, s []rune) error {
+	return CompileWithOptions(w, s, &Options{})
+}
+
+func CompileWithOptions(w io.Writer, s []rune, opts *Options) error {
 	scanner := NewScanner(s)
 	tokens, err := scanner.Scan()
 	if err != nil {
@@ -17,10 +67,25 @@ func Compile(w io.Writer, s []rune) error {
 		return err
 	}
 
-	gen := NewCodegen(w, objects)
-	if err = gen.Gen(); err != nil {
+	funcs, err := GenProgramIR(objects)
+	if err != nil {
+		return err
+	}
+	funcs, err = RunPasses(objects, funcs, opts)
+	if err != nil {
 		return err
 	}
+	if opts.Format == FormatIR {
+		return WriteIR(w, funcs)
+	}
 
-	return nil
+	m, err := NewCodegen(objects, funcs, opts).Gen()
+	if err != nil {
+		return err
+	}
+
+	if opts.Format == FormatWasm {
+		return WriteWasm(w, m)
+	}
+	return WriteWat(w, m)
 }
@@ -0,0 +1,121 @@
+package cc
+
+// EliminateDeadCode removes the blocks which can't be reached, the values
+// which are never used, and the blocks which only jump elsewhere, and merges
+// a block with its only predecessor, until nothing changes.
+func EliminateDeadCode(f *IRFunc) {
+	for changed := true; changed; {
+		changed = removeUnreachable(f)
+		changed = threadJumps(f) || changed
+		changed = mergeBlocks(f) || changed
+		changed = removeDeadValues(f) || changed
+	}
+}
+
+func removeUnreachable(f *IRFunc) bool {
+	reachable := make(map[*IRBlock]bool)
+	for _, b := range f.ReversePostorder() {
+		reachable[b] = true
+	}
+	blocks := f.Blocks[:0]
+	for _, b := range f.Blocks {
+		if reachable[b] {
+			blocks = append(blocks, b)
+		}
+	}
+	changed := len(blocks) < len(f.Blocks)
+	f.Blocks = blocks
+	return changed
+}
+
+// threadJumps makes the jumps to a block which only jumps elsewhere go there
+// directly, and turns a branch to the same block either way into a jump.
+func threadJumps(f *IRFunc) bool {
+	changed := false
+	for _, b := range f.Blocks[1:] {
+		if len(b.Instrs) != 1 || b.Instrs[0].Op != IRJump || b.Instrs[0].Targets[0] == b {
+			continue
+		}
+		to := b.Instrs[0].Targets[0]
+		for _, p := range f.Blocks {
+			for i, t := range p.Succs() {
+				if t == b {
+					p.Succs()[i] = to
+					changed = true
+				}
+			}
+		}
+	}
+
+	for _, b := range f.Blocks {
+		last := b.Instrs[len(b.Instrs)-1]
+		if last.Op == IRBranch && last.Targets[0] == last.Targets[1] {
+			b.Instrs[len(b.Instrs)-1] = &IRInstr{Op: IRJump, Targets: last.Targets[:1]}
+			changed = true
+		}
+	}
+	return changed
+}
+
+// mergeBlocks appends a block to its only predecessor when that one jumps to
+// it.
+func mergeBlocks(f *IRFunc) bool {
+	changed := false
+	rpo := f.ReversePostorder()
+	preds := Preds(rpo)
+	merged := make(map[*IRBlock]bool)
+	for _, b := range rpo {
+		if merged[b] {
+			continue
+		}
+		for {
+			last := b.Instrs[len(b.Instrs)-1]
+			if last.Op != IRJump {
+				break
+			}
+			next := last.Targets[0]
+			if next == b || next == f.Blocks[0] || len(preds[next]) != 1 {
+				break
+			}
+			b.Instrs = append(b.Instrs[:len(b.Instrs)-1], next.Instrs...)
+			merged[next] = true
+			changed = true
+		}
+	}
+
+	blocks := f.Blocks[:0]
+	for _, b := range f.Blocks {
+		if !merged[b] {
+			blocks = append(blocks, b)
+		}
+	}
+	f.Blocks = blocks
+	return changed
+}
+
+// removeDeadValues removes the instructions without side effects whose
+// result is never used.
+func removeDeadValues(f *IRFunc) bool {
+	uses := make(map[*IRReg]int)
+	for _, b := range f.Blocks {
+		for _, instr := range b.Instrs {
+			for _, a := range instr.Args {
+				uses[a]++
+			}
+		}
+	}
+
+	changed := false
+	for _, b := range f.Blocks {
+		instrs := b.Instrs[:0]
+		for _, instr := range b.Instrs {
+			if instr.Dst != nil && uses[instr.Dst] == 0 && !instr.Op.HasSideEffects() {
+				changed = true
+				continue
+			}
+			instrs = append(instrs, instr)
+		}
+		b.Instrs = instrs
+	}
+	return changed
+}
@@ -0,0 +1,227 @@
+package cc
+
+import "math/bits"
+
+// FoldConstants evaluates the instructions whose operands are constants,
+// turns branches on a constant into jumps and simplifies arithmetic with an
+// identity or a power of two, until nothing changes. Only registers assigned
+// once are known to hold a constant: a promoted local assigned in several
+// places isn't one, and neither is a parameter, which the caller assigns
+// on entry.
+func FoldConstants(f *IRFunc) {
+	for changed := true; changed; {
+		changed = false
+		defs := make(map[*IRReg]int)
+		for _, p := range f.Params {
+			defs[p]++
+		}
+		for _, b := range f.Blocks {
+			for _, instr := range b.Instrs {
+				if instr.Dst != nil {
+					defs[instr.Dst]++
+				}
+			}
+		}
+		consts := make(map[*IRReg]int64)
+		for _, b := range f.Blocks {
+			for _, instr := range b.Instrs {
+				if instr.Op == IRConst && defs[instr.Dst] == 1 {
+					consts[instr.Dst] = instr.Imm
+				}
+			}
+		}
+
+		for _, b := range f.Blocks {
+			var instrs []*IRInstr
+			for _, instr := range b.Instrs {
+				folded := foldInstr(f, instr, consts)
+				if folded == nil {
+					instrs = append(instrs, instr)
+					continue
+				}
+				instrs = append(instrs, folded...)
+				changed = true
+			}
+			b.Instrs = instrs
+		}
+	}
+}
+
+// foldInstr returns the instructions replacing instr, or nil if it can't be
+// simplified.
+func foldInstr(f *IRFunc, instr *IRInstr, consts map[*IRReg]int64) []*IRInstr {
+	if instr.Op == IRBranch {
+		cond, ok := consts[instr.Args[0]]
+		if !ok {
+			return nil
+		}
+		target := instr.Targets[0]
+		if cond == 0 {
+			target = instr.Targets[1]
+		}
+		return []*IRInstr{{Op: IRJump, Targets: []*IRBlock{target}}}
+	}
+	if instr.Dst == nil || instr.Op == IRConst {
+		return nil
+	}
+
+	args := make([]int64, len(instr.Args))
+	known := len(instr.Args) > 0
+	for i, a := range instr.Args {
+		v, ok := consts[a]
+		args[i] = v
+		known = known && ok
+	}
+	if known {
+		if v, ok := evalIR(instr.Op, instr.Args[0].Type, args); ok {
+			return []*IRInstr{{Op: IRConst, Dst: instr.Dst, Imm: wrapIR(instr.Dst.Type, v)}}
+		}
+		return nil
+	}
+	if len(instr.Args) != 2 {
+		return nil
+	}
+	return simplifyIR(f, instr, consts)
+}
+
+// simplifyIR simplifies a binary operation with one constant operand.
+func simplifyIR(f *IRFunc, instr *IRInstr, consts map[*IRReg]int64) []*IRInstr {
+	x, y := instr.Args[0], instr.Args[1]
+	c, ok := consts[y]
+	if !ok {
+		// Constants are only on the left of a commutative operation.
+		switch instr.Op {
+		case IRAdd, IRMul, IRAnd, IROr:
+			if c, ok = consts[x]; !ok {
+				return nil
+			}
+			x, y = y, x
+		default:
+			return nil
+		}
+	}
+
+	t := instr.Dst.Type
+	copyX := []*IRInstr{{Op: IRCopy, Dst: instr.Dst, Args: []*IRReg{x}}}
+	zero := []*IRInstr{{Op: IRConst, Dst: instr.Dst}}
+	width := int64(wasmSize(t) * 8)
+	switch instr.Op {
+	case IRAdd, IRSub, IROr, IRShl, IRShr, IRShrU:
+		if c == 0 {
+			return copyX
+		}
+	case IRAnd:
+		switch c {
+		case 0:
+			return zero
+		case -1:
+			return copyX
+		}
+	case IRMul:
+		switch {
+		case c == 0:
+			return zero
+		case c == 1:
+			return copyX
+		case c > 0 && c&(c-1) == 0:
+			k := f.NewReg(t)
+			return []*IRInstr{
+				{Op: IRConst, Dst: k, Imm: int64(bits.TrailingZeros64(uint64(c)))},
+				{Op: IRShl, Dst: instr.Dst, Args: []*IRReg{x, k}},
+			}
+		}
+	case IRDiv:
+		switch {
+		case c == 1:
+			return copyX
+		case c > 1 && c&(c-1) == 0:
+			// Shifting rounds towards negative infinity, so negative
+			// dividends are biased by c-1 first: x / 2^n is
+			// (x + (x >> width-1 >>> width-n)) >> n.
+			n := int64(bits.TrailingZeros64(uint64(c)))
+			sign, bias, sum := f.NewReg(t), f.NewReg(t), f.NewReg(t)
+			k1, k2, k3 := f.NewReg(t), f.NewReg(t), f.NewReg(t)
+			return []*IRInstr{
+				{Op: IRConst, Dst: k1, Imm: width - 1},
+				{Op: IRShr, Dst: sign, Args: []*IRReg{x, k1}},
+				{Op: IRConst, Dst: k2, Imm: width - n},
+				{Op: IRShrU, Dst: bias, Args: []*IRReg{sign, k2}},
+				{Op: IRAdd, Dst: sum, Args: []*IRReg{x, bias}},
+				{Op: IRConst, Dst: k3, Imm: n},
+				{Op: IRShr, Dst: instr.Dst, Args: []*IRReg{sum, k3}},
+			}
+		}
+	}
+	return nil
+}
+
+// evalIR computes an operation on constants of wasm type t, reporting false
+// if it can't be evaluated at compile time, such as a division which traps.
+func evalIR(op IROp, t string, args []int64) (int64, bool) {
+	a := wrapIR(t, args[0])
+	var b int64
+	if len(args) > 1 {
+		b = wrapIR(t, args[1])
+	}
+	width := uint(wasmSize(t) * 8)
+	bool2int := func(v bool) int64 {
+		if v {
+			return 1
+		}
+		return 0
+	}
+
+	switch op {
+	case IRCopy, IRExtend:
+		return a, true
+	case IRWrap:
+		return int64(int32(a)), true
+	case IRExtend8:
+		return int64(int8(a)), true
+	case IRExtend16:
+		return int64(int16(a)), true
+	case IREqz:
+		return bool2int(a == 0), true
+	case IRAdd:
+		return a + b, true
+	case IRSub:
+		return a - b, true
+	case IRMul:
+		return a * b, true
+	case IRDiv:
+		if b == 0 || b == -1 && a == wrapIR(t, -1<<(width-1)) {
+			return 0, false
+		}
+		return a / b, true
+	case IRAnd:
+		return a & b, true
+	case IROr:
+		return a | b, true
+	case IRShl:
+		return a << (uint(b) % width), true
+	case IRShr:
+		return a >> (uint(b) % width), true
+	case IRShrU:
+		if t == "i32" {
+			return int64(uint32(a) >> (uint(b) % width)), true
+		}
+		return int64(uint64(a) >> (uint(b) % width)), true
+	case IREq:
+		return bool2int(a == b), true
+	case IRNe:
+		return bool2int(a != b), true
+	case IRLt:
+		return bool2int(a < b), true
+	case IRLe:
+		return bool2int(a <= b), true
+	}
+	return 0, false
+}
+
+// wrapIR wraps a constant to wasm type t.
+func wrapIR(t string, v int64) int64 {
+	if t == "i32" {
+		return int64(int32(v))
+	}
+	return v
+}
@@ -0,0 +1,258 @@
+package cc
+
+// Initializer is the parsed initializer of an object, shaped like its type.
+// Arrays have a child per element and structs a child per named member,
+// unions only one for their first member. Scalars, and aggregates
+// initialized by a value of their type, have an expression instead.
+type Initializer struct {
+	Type     *Type
+	Expr     *Node
+	Children []*Initializer
+	Member   *StructMember // member initialized by a child of a struct
+
+	// An array of unknown length gets a child for every element of the
+	// initializer, which determines its length.
+	IsFlexible bool
+}
+
+func NewInitializer(t *Type, isFlexible bool) *Initializer {
+	init := &Initializer{Type: t}
+	switch {
+	case t.IsUnsizedArray():
+		init.IsFlexible = isFlexible
+	case t.Kind == TYArray:
+		for i := 0; i < t.Val.(int); i++ {
+			init.Children = append(init.Children, NewInitializer(t.Base, false))
+		}
+	case t.IsStructUnion():
+		for _, m := range t.Val.(*StructVal).Members {
+			if m.IsBitfield && m.Name == "" || m.Type.IsUnsizedArray() {
+				continue
+			}
+			child := NewInitializer(m.Type, false)
+			child.Member = m
+			init.Children = append(init.Children, child)
+			if t.Kind == TYUnion {
+				break
+			}
+		}
+	}
+	return init
+}
+
+// Initializer parses the initializer of an object of type t. The returned
+// type is t, unless t is an array of unknown length which is completed by
+// the initializer.
+func (p *Parser) Initializer(t *Type) (*Initializer, *Type) {
+	init := NewInitializer(t, true)
+	p.InitValue(init, nil)
+	if init.IsFlexible {
+		t = NewType(TYArray, t.Base, len(init.Children))
+		init.Type = t
+	}
+	return init, t
+}
+
+// InitValue parses the initializer of init. If expr is not nil, it was
+// already parsed as the start of the value, which is then a single
+// expression or a list whose braces are elided.
+func (p *Parser) InitValue(init *Initializer, expr *Node) {
+	t := init.Type
+	switch {
+	case expr == nil && t.Kind == TYArray && t.Base.Kind == TYChar && p.Current().Kind == TKString:
+		p.StringInitializer(init)
+	case t.Kind == TYArray:
+		p.ListInitializer(init, expr)
+	case t.IsStructUnion():
+		// Either a value of the same type, or the first member of a list
+		// whose braces are elided. A string can only be the latter.
+		if expr == nil && !p.Current().Equal(TKPunctuator, "{") && p.Current().Kind != TKString {
+			expr = p.Assign()
+		}
+		if expr != nil && expr.Type.IsCompatible(t) {
+			init.Expr = expr
+			return
+		}
+		p.ListInitializer(init, expr)
+	case expr != nil:
+		init.Expr = expr
+	case p.Current().Equal(TKPunctuator, "{"):
+		p.Next()
+		init.Expr = p.Assign()
+		if !p.InitListEnd() {
+			panic(p.Current().Errorf("expected '}', got '%s' instead", p.Current().Lexeme))
+		}
+	default:
+		init.Expr = p.Assign()
+	}
+}
+
+// ListInitializer parses the elements of an array, struct or union. Without
+// braces, it takes only as many elements as the type has, the first one
+// starting with first if it was already parsed.
+func (p *Parser) ListInitializer(init *Initializer, first *Node) {
+	braced := first == nil && p.Current().Equal(TKPunctuator, "{")
+	if braced {
+		p.Next()
+	}
+
+	for i := 0; ; i++ {
+		if braced && p.InitListEnd() {
+			return
+		}
+		if !braced && first == nil && (i == len(init.Children) && !init.IsFlexible || p.AtInitListEnd()) {
+			return
+		}
+		if i > 0 {
+			p.Consume(TKPunctuator, ",")
+		}
+
+		if init.IsFlexible {
+			init.Children = append(init.Children, NewInitializer(init.Type.Base, false))
+		}
+		if i >= len(init.Children) {
+			tok := p.Current()
+			if first != nil {
+				tok = first.Tok
+			}
+			panic(tok.Errorf("excess elements in initializer"))
+		}
+		p.InitValue(init.Children[i], first)
+		first = nil
+	}
+}
+
+// StringInitializer initializes a char array with the bytes of a string
+// literal, including the null byte if it fits.
+func (p *Parser) StringInitializer(init *Initializer) {
+	tok := p.Current()
+	p.Next()
+	bs := append(append([]byte{}, tok.Val.(*String).Val...), 0)
+	if init.IsFlexible {
+		for range bs {
+			init.Children = append(init.Children, NewInitializer(init.Type.Base, false))
+		}
+	}
+	for i, child := range init.Children {
+		if i == len(bs) {
+			break
+		}
+		child.Expr = NewNode(NKNum, &Number{Val: int(int8(bs[i]))}, tok)
+	}
+}
+
+// AtInitListEnd reports whether the current token ends an initializer list,
+// which may have a trailing comma.
+func (p *Parser) AtInitListEnd() bool {
+	return p.Current().Equal(TKPunctuator, "}") ||
+		p.Current().Equal(TKPunctuator, ",") && p.tokens[p.pos+1].Equal(TKPunctuator, "}")
+}
+
+// InitListEnd consumes the end of an initializer list if it is at the
+// current token.
+func (p *Parser) InitListEnd() bool {
+	if !p.AtInitListEnd() {
+		return false
+	}
+	if p.Current().Equal(TKPunctuator, ",") {
+		p.Next()
+	}
+	p.Next()
+	return true
+}
+
+// LocalInit lowers the initializer of the local o to assignments to its
+// elements. Aggregates are zeroed first, so that elements without an
+// initializer are zero.
+func (p *Parser) LocalInit(o *Object, init *Initializer, tok *Token) *Node {
+	v := NewNode(NKVariable, &Variable{Object: o}, tok)
+	if init.Expr != nil {
+		return NewNode(NKAssign, &Binary{Lhs: v, Rhs: init.Expr}, tok)
+	}
+	return initAssigns(NewNode(NKMemZero, &Variable{Object: o}, tok), init, v, tok)
+}
+
+// initAssigns appends the assignments of init to lhs to the expression n.
+func initAssigns(n *Node, init *Initializer, lhs *Node, tok *Token) *Node {
+	if init.Expr != nil {
+		assign := NewNode(NKAssign, &Binary{Lhs: lhs, Rhs: init.Expr}, tok)
+		return NewNode(NKComma, &Binary{Lhs: n, Rhs: assign}, tok)
+	}
+
+	for i, child := range init.Children {
+		var elem *Node
+		if init.Type.Kind == TYArray {
+			idx := NewNode(NKNum, &Number{Val: i}, tok)
+			elem = NewNode(NKDeRef, &Unary{Expr: NewNodeAdd(lhs, idx, tok)}, tok)
+		} else {
+			elem = NewNode(NKMember, &MemberAccess{Struct: lhs, Member: child.Member}, tok)
+		}
+		n = initAssigns(n, child, elem, tok)
+	}
+	return n
+}
+
+// GlobalData computes the initial value of the global o. Addresses of other
+// objects are recorded as relocations, since they are only known when code
+// is generated.
+func (p *Parser) GlobalData(o *Object, init *Initializer) {
+	buf := make([]byte, o.Type.Size)
+	writeData(o.Global, buf, init, 0)
+	o.Global.Val = buf
+}
+
+func writeData(g *Global, buf []byte, init *Initializer, offset int) {
+	t := init.Type
+	switch {
+	case t.Kind == TYArray:
+		for i, child := range init.Children {
+			writeData(g, buf, child, offset+i*t.Base.Size)
+		}
+		return
+	case t.IsStructUnion() && init.Expr == nil:
+		for _, child := range init.Children {
+			m := child.Member
+			if m.IsBitfield && child.Expr != nil {
+				b := buf[offset+m.Offset:]
+				mask := 1<<uint(m.BitWidth) - 1
+				v := readInt(b, m.Type.Size)&^(mask<<uint(m.BitOffset)) | (castValue(eval(child.Expr), m.Type)&mask)<<uint(m.BitOffset)
+				writeInt(b, v, m.Type.Size)
+				continue
+			}
+			writeData(g, buf, child, offset+m.Offset)
+		}
+		return
+	}
+
+	if init.Expr == nil {
+		return
+	}
+	if t.IsStructUnion() {
+		panic(init.Expr.Tok.Errorf("initializer element is not constant"))
+	}
+
+	var label *Object
+	v := evalReloc(init.Expr, &label)
+	if label == nil {
+		writeInt(buf[offset:], castValue(v, t), t.Size)
+		return
+	}
+	if t.Size != 4 {
+		panic(init.Expr.Tok.Errorf("initializer element is not computable at load time"))
+	}
+	g.Relocs = append(g.Relocs, &Reloc{Offset: offset, Label: label, Addend: v})
+}
+
+func readInt(b []byte, size int) int {
+	v := 0
+	for i := size - 1; i >= 0; i-- {
+		v = v<<8 | int(b[i])
+	}
+	return v
+}
+
+func writeInt(b []byte, v int, size int) {
+	for i := 0; i < size; i++ {
+		b[i] = byte(v >> uint(8*i))
+	}
+}
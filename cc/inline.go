@@ -0,0 +1,233 @@
+package cc
+
+// InlineLimit is the number of instructions up to which a function without
+// calls is inlined. When optimizing for size, it is InlineSizeLimit, so that
+// the copy is hardly larger than the call.
+const (
+	InlineLimit     = 24
+	InlineSizeLimit = 6
+)
+
+// InlineFunctions replaces the calls to small functions which call no other
+// function, and to static functions called only once, by a copy of their
+// body. The locals of the copy are added to the frame of the caller. Static
+// functions left unused, and not exported, are removed.
+func InlineFunctions(objects []*Object, funcs []*IRFunc, opts *Options) []*IRFunc {
+	byName := make(map[string]*IRFunc)
+	for _, f := range funcs {
+		byName[f.Name] = f
+	}
+
+	// Functions whose address is taken may be called from anywhere.
+	calls := make(map[string]int)
+	addressTaken := make(map[string]bool)
+	for _, o := range objects {
+		if o.Kind != OKGlobal {
+			continue
+		}
+		for _, r := range o.Global.Relocs {
+			if r.Label.Kind == OKFunction {
+				addressTaken[r.Label.Name] = true
+			}
+		}
+	}
+	for _, f := range funcs {
+		for _, b := range f.Blocks {
+			for _, instr := range b.Instrs {
+				switch instr.Op {
+				case IRCall:
+					calls[instr.Name]++
+				case IRFuncAddr:
+					addressTaken[instr.Name] = true
+				}
+			}
+		}
+	}
+
+	exported := func(f *IRFunc) bool {
+		o := f.Object
+		if o.Export != "" || opts.ExportAll || !o.IsStatic && len(opts.Exports) == 0 {
+			return true
+		}
+		for _, e := range opts.Exports {
+			if e == o.Name {
+				return true
+			}
+		}
+		return false
+	}
+	limit := InlineLimit
+	if opts.OptSize {
+		limit = InlineSizeLimit
+	}
+	once := func(f *IRFunc) bool {
+		return f.Object.IsStatic && calls[f.Name] == 1 && !addressTaken[f.Name] && !exported(f)
+	}
+
+	// Callees are visited before their callers, so that a function which
+	// only calls small ones becomes small and free of calls itself.
+	var order []*IRFunc
+	visited := make(map[*IRFunc]bool)
+	var visit func(f *IRFunc)
+	visit = func(f *IRFunc) {
+		visited[f] = true
+		for _, b := range f.Blocks {
+			for _, instr := range b.Instrs {
+				if callee := byName[instr.Name]; instr.Op == IRCall && callee != nil && !visited[callee] {
+					visit(callee)
+				}
+			}
+		}
+		order = append(order, f)
+	}
+	for _, f := range funcs {
+		if !visited[f] {
+			visit(f)
+		}
+	}
+
+	inlined := make(map[*IRFunc]bool)
+	for _, f := range order {
+		var sites []*IRInstr
+		for _, b := range f.Blocks {
+			for _, instr := range b.Instrs {
+				callee := byName[instr.Name]
+				if instr.Op == IRCall && callee != nil && callee != f && canInline(callee, instr) && (isSmallLeaf(callee, limit) || once(callee)) {
+					sites = append(sites, instr)
+				}
+			}
+		}
+		for _, call := range sites {
+			callee := byName[call.Name]
+			InlineCall(f, call, callee)
+			inlined[callee] = true
+		}
+	}
+
+	// A static function inlined everywhere is no longer needed.
+	calls = make(map[string]int)
+	for _, f := range funcs {
+		for _, b := range f.Blocks {
+			for _, instr := range b.Instrs {
+				if instr.Op == IRCall && instr.Name != f.Name {
+					calls[instr.Name]++
+				}
+			}
+		}
+	}
+	result := funcs[:0]
+	for _, f := range funcs {
+		if !inlined[f] || !f.Object.IsStatic || calls[f.Name] > 0 || addressTaken[f.Name] || exported(f) {
+			result = append(result, f)
+		}
+	}
+	return result
+}
+
+// canInline reports whether the function can be inlined at the call at all:
+// the arguments must match the parameters, as they may not when calling a
+// function without a prototype, and it must not move the stack pointer,
+// which is restored when returning.
+func canInline(f *IRFunc, call *IRInstr) bool {
+	if len(call.Args) != len(f.Params) || call.Dst.Type != f.Result {
+		return false
+	}
+	for i, a := range call.Args {
+		if a.Type != f.Params[i].Type {
+			return false
+		}
+	}
+	for _, b := range f.Blocks {
+		for _, instr := range b.Instrs {
+			if instr.Op == IRGetSP || instr.Op == IRSetSP {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func isSmallLeaf(f *IRFunc, limit int) bool {
+	n := 0
+	for _, b := range f.Blocks {
+		for _, instr := range b.Instrs {
+			if instr.Op == IRCall || instr.Op == IRCallIndirect {
+				return false
+			}
+			n++
+		}
+	}
+	return n <= limit
+}
+
+// InlineCall replaces the call in f by a copy of the body of callee. The
+// block of the call is split after it, and the returns of the copy assign
+// the result of the call and jump to the second half.
+func InlineCall(f *IRFunc, call *IRInstr, callee *IRFunc) {
+	var block *IRBlock
+	index := 0
+	for _, b := range f.Blocks {
+		for i, instr := range b.Instrs {
+			if instr == call {
+				block, index = b, i
+			}
+		}
+	}
+
+	regs := make(map[*IRReg]*IRReg)
+	reg := func(r *IRReg) *IRReg {
+		if _, ok := regs[r]; !ok {
+			regs[r] = f.NewReg(r.Type)
+		}
+		return regs[r]
+	}
+	locals := make(map[*Object]*Object)
+	for _, o := range callee.Frame {
+		local := *o
+		local.Local = &Local{}
+		locals[o] = &local
+		f.Frame = append(f.Frame, &local)
+	}
+	f.LayoutFrame()
+
+	blocks := make(map[*IRBlock]*IRBlock)
+	for _, b := range callee.Blocks {
+		blocks[b] = f.NewBlock()
+	}
+	cont := f.NewBlock()
+	cont.Instrs = append(cont.Instrs, block.Instrs[index+1:]...)
+
+	block.Instrs = block.Instrs[:index]
+	for i, param := range callee.Params {
+		block.Instrs = append(block.Instrs, &IRInstr{Op: IRCopy, Dst: reg(param), Args: []*IRReg{call.Args[i]}})
+	}
+	block.Instrs = append(block.Instrs, &IRInstr{Op: IRJump, Targets: []*IRBlock{blocks[callee.Blocks[0]]}})
+
+	for _, b := range callee.Blocks {
+		clone := blocks[b]
+		for _, instr := range b.Instrs {
+			if instr.Op == IRReturn {
+				clone.Instrs = append(clone.Instrs,
+					&IRInstr{Op: IRCopy, Dst: call.Dst, Args: []*IRReg{reg(instr.Args[0])}},
+					&IRInstr{Op: IRJump, Targets: []*IRBlock{cont}})
+				continue
+			}
+			c := *instr
+			if c.Dst != nil {
+				c.Dst = reg(c.Dst)
+			}
+			c.Args = make([]*IRReg, len(instr.Args))
+			for i, a := range instr.Args {
+				c.Args[i] = reg(a)
+			}
+			c.Targets = make([]*IRBlock, len(instr.Targets))
+			for i, t := range instr.Targets {
+				c.Targets[i] = blocks[t]
+			}
+			if c.Op == IRFrame {
+				c.Object = locals[c.Object]
+			}
+			clone.Instrs = append(clone.Instrs, &c)
+		}
+	}
+}
@@ -0,0 +1,319 @@
+package cc
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// The IR sits between the AST and wasm. A function is a graph of basic
+// blocks, each a list of instructions ending with a jump, a branch or a
+// return. Values live in virtual registers of a wasm value type, which may be
+// assigned more than once; memory is only accessed by explicit loads and
+// stores.
+
+type IROp int
+
+const (
+	IRConst        IROp = iota // Dst = Imm
+	IRCopy                     // Dst = Args[0]
+	IRAdd                      // Dst = Args[0] + Args[1]
+	IRSub                      // Dst = Args[0] - Args[1]
+	IRMul                      // Dst = Args[0] * Args[1]
+	IRDiv                      // Dst = Args[0] / Args[1], signed
+	IRAnd                      // Dst = Args[0] & Args[1]
+	IROr                       // Dst = Args[0] | Args[1]
+	IRShl                      // Dst = Args[0] << Args[1]
+	IRShr                      // Dst = Args[0] >> Args[1], signed
+	IRShrU                     // Dst = Args[0] >> Args[1], unsigned
+	IREq                       // Dst = Args[0] == Args[1]
+	IRNe                       // Dst = Args[0] != Args[1]
+	IRLt                       // Dst = Args[0] < Args[1], signed
+	IRLe                       // Dst = Args[0] <= Args[1], signed
+	IREqz                      // Dst = Args[0] == 0
+	IRExtend                   // Dst = Args[0] sign-extended from i32 to i64
+	IRWrap                     // Dst = Args[0] truncated from i64 to i32
+	IRExtend8                  // Dst = Args[0] sign-extended from 8 bits
+	IRExtend16                 // Dst = Args[0] sign-extended from 16 bits
+	IRLoad                     // Dst = Size bytes at Args[0]
+	IRStore                    // Size bytes at Args[0] = Args[1]
+	IRFrame                    // Dst = address of the local Object in the frame
+	IRGlobal                   // Dst = address of the global Object
+	IRFuncAddr                 // Dst = address of the function Name
+	IRGetSP                    // Dst = stack pointer
+	IRSetSP                    // stack pointer = Args[0]
+	IRCall                     // Dst = Name(Args...)
+	IRCallIndirect             // Dst = Args[len(Args)-1](Args[:len(Args)-1]...)
+	IRMemCopy                  // copy Imm bytes from Args[1] to Args[0]
+	IRMemFill                  // zero Imm bytes at Args[0]
+	IRJump                     // jump to Targets[0]
+	IRBranch                   // jump to Targets[0] if Args[0] != 0, else Targets[1]
+	IRReturn                   // pop the frame and return Args[0]
+)
+
+var irOpNames = []string{
+	"const", "copy", "add", "sub", "mul", "div", "and", "or", "shl", "shr", "shr_u",
+	"eq", "ne", "lt", "le", "eqz", "extend", "wrap", "extend8", "extend16",
+	"load", "store", "frame", "global", "func", "getsp", "setsp", "call", "call_indirect",
+	"memcopy", "memfill", "jump", "br", "ret",
+}
+
+func (op IROp) String() string {
+	return irOpNames[op]
+}
+
+// IsTerminator reports whether the op ends a basic block.
+func (op IROp) IsTerminator() bool {
+	return op == IRJump || op == IRBranch || op == IRReturn
+}
+
+// HasSideEffects reports whether an instruction with the op must be kept
+// even if its result is unused.
+func (op IROp) HasSideEffects() bool {
+	switch op {
+	case IRStore, IRSetSP, IRCall, IRCallIndirect, IRMemCopy, IRMemFill:
+		return true
+	}
+	return op.IsTerminator()
+}
+
+// IRReg is a virtual register holding a wasm value type.
+type IRReg struct {
+	ID   int
+	Type string
+}
+
+func (r *IRReg) String() string {
+	return fmt.Sprintf("%%%d", r.ID)
+}
+
+type IRInstr struct {
+	Op   IROp
+	Dst  *IRReg
+	Args []*IRReg
+	Imm  int64
+
+	// Loads and stores access Size bytes, loads of less than the register
+	// are sign-extended unless Unsigned.
+	Size     int
+	Unsigned bool
+
+	Name    string  // function called or whose address is taken
+	Object  *Object // local of IRFrame, global of IRGlobal
+	Targets []*IRBlock
+}
+
+type IRBlock struct {
+	ID     int
+	Instrs []*IRInstr // the last one is a terminator
+}
+
+// Succs returns the blocks the block may jump to.
+func (b *IRBlock) Succs() []*IRBlock {
+	if len(b.Instrs) == 0 {
+		return nil
+	}
+	return b.Instrs[len(b.Instrs)-1].Targets
+}
+
+type IRFunc struct {
+	Name   string
+	Object *Object
+	Params []*IRReg // one per wasm param, named after Object.Function.Params
+	Result string
+	Blocks []*IRBlock // the entry block first
+	Regs   []*IRReg
+
+	// The locals kept in memory, in a frame of FrameSize bytes pushed on
+	// the stack on entry. The frame is aligned to FrameAlign, which is
+	// more than StackAlign if a local requires it.
+	Frame      []*Object
+	FrameSize  int
+	FrameAlign int
+}
+
+func (f *IRFunc) NewReg(t string) *IRReg {
+	r := &IRReg{ID: len(f.Regs), Type: t}
+	f.Regs = append(f.Regs, r)
+	return r
+}
+
+func (f *IRFunc) NewBlock() *IRBlock {
+	id := 0
+	if len(f.Blocks) > 0 {
+		id = f.Blocks[len(f.Blocks)-1].ID + 1
+	}
+	b := &IRBlock{ID: id}
+	f.Blocks = append(f.Blocks, b)
+	return b
+}
+
+// StackAlign is the alignment of $sp, which every frame keeps.
+const StackAlign = 16
+
+// LayoutFrame assigns the offsets of the locals in the frame.
+func (f *IRFunc) LayoutFrame() {
+	offset := 0
+	f.FrameAlign = StackAlign
+	for _, l := range f.Frame {
+		offset = alignTo(offset, l.Alignment())
+		l.Local.Offset = offset
+		offset += l.Type.Size
+		if l.Alignment() > f.FrameAlign {
+			f.FrameAlign = l.Alignment()
+		}
+	}
+	f.FrameSize = alignTo(offset, StackAlign)
+}
+
+// WriteIR writes the IR of the functions in its textual form.
+func WriteIR(w io.Writer, funcs []*IRFunc) error {
+	var sb strings.Builder
+	for i, f := range funcs {
+		if i > 0 {
+			sb.WriteString("\n")
+		}
+		sb.WriteString(f.String())
+	}
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+func (f *IRFunc) String() string {
+	var sb strings.Builder
+	params := make([]string, len(f.Params))
+	for i, p := range f.Params {
+		params[i] = fmt.Sprintf("%s %s", p, p.Type)
+	}
+	fmt.Fprintf(&sb, "func %s(%s) %s frame %d {\n", f.Name, strings.Join(params, ", "), f.Result, f.FrameSize)
+	for _, b := range f.Blocks {
+		fmt.Fprintf(&sb, "b%d:\n", b.ID)
+		for _, instr := range b.Instrs {
+			fmt.Fprintf(&sb, "  %s\n", instr)
+		}
+	}
+	sb.WriteString("}\n")
+	return sb.String()
+}
+
+func (i *IRInstr) String() string {
+	var sb strings.Builder
+	if i.Dst != nil {
+		fmt.Fprintf(&sb, "%s:%s = ", i.Dst, i.Dst.Type)
+	}
+	sb.WriteString(i.Op.String())
+	if i.Op == IRLoad || i.Op == IRStore {
+		fmt.Fprintf(&sb, ".%d", i.Size)
+		if i.Unsigned {
+			sb.WriteString("u")
+		}
+	}
+
+	var operands []string
+	switch i.Op {
+	case IRConst, IRMemCopy, IRMemFill:
+		operands = append(operands, fmt.Sprint(i.Imm))
+	case IRFrame:
+		operands = append(operands, fmt.Sprintf("%d", i.Object.Local.Offset))
+	case IRGlobal:
+		name := i.Object.Name
+		if name == "" {
+			name = "<anon>"
+		}
+		operands = append(operands, "@"+name)
+	case IRFuncAddr, IRCall:
+		operands = append(operands, "@"+i.Name)
+	}
+	for _, a := range i.Args {
+		operands = append(operands, a.String())
+	}
+	for _, t := range i.Targets {
+		operands = append(operands, fmt.Sprintf("b%d", t.ID))
+	}
+	if len(operands) > 0 {
+		sb.WriteString(" " + strings.Join(operands, ", "))
+	}
+	if i.Op == IRFrame && i.Object.Name != "" {
+		sb.WriteString(" ; " + i.Object.Name)
+	}
+	return sb.String()
+}
+
+// ReversePostorder returns the blocks reachable from the entry block in
+// reverse postorder, so that a block comes before its successors except
+// along back edges.
+func (f *IRFunc) ReversePostorder() []*IRBlock {
+	var order []*IRBlock
+	visited := make(map[*IRBlock]bool)
+	var visit func(b *IRBlock)
+	visit = func(b *IRBlock) {
+		visited[b] = true
+		for _, s := range b.Succs() {
+			if !visited[s] {
+				visit(s)
+			}
+		}
+		order = append(order, b)
+	}
+	visit(f.Blocks[0])
+
+	for i, j := 0, len(order)-1; i < j; i, j = i+1, j-1 {
+		order[i], order[j] = order[j], order[i]
+	}
+	return order
+}
+
+// Preds returns the predecessors of the reachable blocks, once per edge.
+func Preds(rpo []*IRBlock) map[*IRBlock][]*IRBlock {
+	preds := make(map[*IRBlock][]*IRBlock)
+	for _, b := range rpo {
+		for _, s := range b.Succs() {
+			preds[s] = append(preds[s], b)
+		}
+	}
+	return preds
+}
+
+// Dominators returns the immediate dominator of each block in rpo, the entry
+// block being its own. It is the algorithm of Cooper, Harvey and Kennedy, "A
+// Simple, Fast Dominance Algorithm".
+func Dominators(rpo []*IRBlock, preds map[*IRBlock][]*IRBlock) map[*IRBlock]*IRBlock {
+	index := make(map[*IRBlock]int)
+	for i, b := range rpo {
+		index[b] = i
+	}
+	idom := map[*IRBlock]*IRBlock{rpo[0]: rpo[0]}
+	intersect := func(a, b *IRBlock) *IRBlock {
+		for a != b {
+			for index[a] > index[b] {
+				a = idom[a]
+			}
+			for index[b] > index[a] {
+				b = idom[b]
+			}
+		}
+		return a
+	}
+
+	for changed := true; changed; {
+		changed = false
+		for _, b := range rpo[1:] {
+			var dom *IRBlock
+			for _, p := range preds[b] {
+				if idom[p] == nil {
+					continue
+				}
+				if dom == nil {
+					dom = p
+				} else {
+					dom = intersect(p, dom)
+				}
+			}
+			if idom[b] != dom {
+				idom[b] = dom
+				changed = true
+			}
+		}
+	}
+	return idom
+}
@@ -0,0 +1,424 @@
+package cc
+
+import "errors"
+
+// irBuilder lowers the body of a function to IR. Instructions are appended
+// to the current block; after a terminator a new block is started, which is
+// unreachable unless some later jump targets it.
+type irBuilder struct {
+	fn  *IRFunc
+	cur *IRBlock
+}
+
+// GenProgramIR lowers the functions defined in the program to IR.
+func GenProgramIR(objects []*Object) (funcs []*IRFunc, err error) {
+	defer func() {
+		var r interface{}
+		if r = recover(); r == nil {
+			return
+		}
+
+		var ok bool
+		if err, ok = r.(error); !ok {
+			panic(r)
+		}
+	}()
+	for _, o := range objects {
+		if o.Kind == OKFunction && !o.Function.IsPrototype {
+			funcs = append(funcs, GenIR(o))
+		}
+	}
+	return funcs, nil
+}
+
+// GenIR lowers the function o to IR. The locals, including the parameters,
+// are kept in the frame.
+func GenIR(o *Object) *IRFunc {
+	f := &IRFunc{
+		Name:   o.Name,
+		Object: o,
+		Result: o.Type.Base.WasmType(),
+		Frame:  append([]*Object{}, o.Function.Locals...),
+	}
+	f.LayoutFrame()
+	for _, param := range o.Function.Params {
+		f.Params = append(f.Params, f.NewReg(param.Type.WasmType()))
+	}
+
+	b := &irBuilder{fn: f}
+	b.cur = f.NewBlock()
+	for i, param := range o.Function.Params {
+		addr := b.Frame(param)
+		if param.Type.IsStructUnion() {
+			b.Emit(&IRInstr{Op: IRMemCopy, Args: []*IRReg{addr, f.Params[i]}, Imm: int64(param.Type.Size)})
+			continue
+		}
+		b.Store(param.Type, addr, f.Params[i])
+	}
+
+	b.Stmt(o.Function.Body)
+	// Falling off the end returns 0.
+	b.Emit(&IRInstr{Op: IRReturn, Args: []*IRReg{b.Const(f.Result, 0)}})
+
+	// Drop the empty blocks started after each terminator, which nothing
+	// jumps to.
+	blocks := f.Blocks[:0]
+	for _, block := range f.Blocks {
+		if len(block.Instrs) > 0 {
+			blocks = append(blocks, block)
+		}
+	}
+	f.Blocks = blocks
+	return f
+}
+
+// Emit appends an instruction to the current block.
+func (b *irBuilder) Emit(instr *IRInstr) {
+	b.cur.Instrs = append(b.cur.Instrs, instr)
+	if instr.Op.IsTerminator() {
+		b.cur = b.fn.NewBlock()
+	}
+}
+
+// Op appends an instruction computing a new register of wasm type t.
+func (b *irBuilder) Op(op IROp, t string, args ...*IRReg) *IRReg {
+	dst := b.fn.NewReg(t)
+	b.Emit(&IRInstr{Op: op, Dst: dst, Args: args})
+	return dst
+}
+
+func (b *irBuilder) Const(t string, v int64) *IRReg {
+	dst := b.fn.NewReg(t)
+	b.Emit(&IRInstr{Op: IRConst, Dst: dst, Imm: v})
+	return dst
+}
+
+func (b *irBuilder) Jump(target *IRBlock) {
+	b.Emit(&IRInstr{Op: IRJump, Targets: []*IRBlock{target}})
+}
+
+// Branch jumps to then if cond is nonzero, and to els otherwise.
+func (b *irBuilder) Branch(cond *IRReg, then *IRBlock, els *IRBlock) {
+	if cond.Type != "i32" {
+		cond = b.Op(IRNe, "i32", cond, b.Const(cond.Type, 0))
+	}
+	b.Emit(&IRInstr{Op: IRBranch, Args: []*IRReg{cond}, Targets: []*IRBlock{then, els}})
+}
+
+// SetBlock makes the block the current one.
+func (b *irBuilder) SetBlock(block *IRBlock) {
+	b.cur = block
+}
+
+func (b *irBuilder) Stmt(node *Node) {
+	switch node.Kind {
+	case NKIf:
+		then, els, join := b.fn.NewBlock(), b.fn.NewBlock(), b.fn.NewBlock()
+		b.Branch(b.Expr(node.IfClause.Cond), then, els)
+		b.SetBlock(then)
+		b.Stmt(node.IfClause.Then)
+		b.Jump(join)
+		b.SetBlock(els)
+		if node.IfClause.Else != nil {
+			b.Stmt(node.IfClause.Else)
+		}
+		b.Jump(join)
+		b.SetBlock(join)
+		return
+	case NKFor:
+		if node.ForClause.Init != nil {
+			b.Stmt(node.ForClause.Init)
+		}
+		cond, body, exit := b.fn.NewBlock(), b.fn.NewBlock(), b.fn.NewBlock()
+		b.Jump(cond)
+		b.SetBlock(cond)
+		if node.ForClause.Cond != nil {
+			b.Branch(b.Expr(node.ForClause.Cond), body, exit)
+		} else {
+			b.Jump(body)
+		}
+		b.SetBlock(body)
+		b.Stmt(node.ForClause.Body)
+		if node.ForClause.Increment != nil {
+			b.Expr(node.ForClause.Increment)
+		}
+		b.Jump(cond)
+		b.SetBlock(exit)
+		return
+	case NKBlock:
+		if save := node.Block.SpSave; save != nil {
+			b.Store(IntType, b.Frame(save), b.Op(IRGetSP, "i32"))
+		}
+		for _, n := range node.Block.Stmts {
+			b.Stmt(n)
+		}
+		if save := node.Block.SpSave; save != nil {
+			b.Emit(&IRInstr{Op: IRSetSP, Args: []*IRReg{b.Load(IntType, b.Frame(save))}})
+		}
+		return
+	case NKReturn:
+		b.Emit(&IRInstr{Op: IRReturn, Args: []*IRReg{b.Expr(node.Unary.Expr)}})
+		return
+	case NKExprStmt:
+		b.Expr(node.Unary.Expr)
+		return
+	}
+
+	panic(errors.New("invalid statement"))
+}
+
+func (b *irBuilder) Expr(node *Node) *IRReg {
+	switch node.Kind {
+	case NKNum:
+		return b.Const(node.Type.WasmType(), int64(node.Num.Val))
+	case NKNeg:
+		t := node.Type.WasmType()
+		return b.Op(IRSub, t, b.Const(t, 0), b.Expr(node.Unary.Expr))
+	case NKVariable, NKMember:
+		addr := b.Addr(node)
+		if node.Kind == NKMember && node.MemberAccess.Member.IsBitfield {
+			return b.LoadBitfield(node.MemberAccess.Member, addr)
+		}
+		return b.Load(node.Type, addr)
+	case NKStringLiteral:
+		return b.Addr(node)
+	case NKDeRef:
+		return b.Load(node.Type, b.Expr(node.Unary.Expr))
+	case NKAddr:
+		return b.Addr(node.Unary.Expr)
+	case NKCast:
+		return b.Convert(b.Expr(node.Unary.Expr), node.Unary.Expr.Type, node.Type)
+	case NKAssign:
+		if lhs := node.Binary.Lhs; lhs.Kind == NKMember && lhs.MemberAccess.Member.IsBitfield {
+			return b.StoreBitfield(lhs, node.Binary.Rhs)
+		}
+		addr := b.Addr(node.Binary.Lhs)
+		val := b.Expr(node.Binary.Rhs)
+		if node.Type.IsStructUnion() {
+			// Copy the whole object, the value is the address of the lhs.
+			b.Emit(&IRInstr{Op: IRMemCopy, Args: []*IRReg{addr, val}, Imm: int64(node.Type.Size)})
+			return addr
+		}
+		b.Store(node.Type, addr, val)
+		return val
+	case NKComma:
+		b.Expr(node.Binary.Lhs)
+		return b.Expr(node.Binary.Rhs)
+	case NKStmtsExpr:
+		stmts := node.Block.Stmts
+		for _, n := range stmts[:len(stmts)-1] {
+			b.Stmt(n)
+		}
+		return b.Expr(stmts[len(stmts)-1].Unary.Expr)
+	case NKMemZero:
+		b.Emit(&IRInstr{Op: IRMemFill, Args: []*IRReg{b.Frame(node.Variable.Object)}, Imm: int64(node.Variable.Object.Type.Size)})
+		return b.Const("i32", 0)
+	case NKAlloca:
+		// Grow the stack by the size rounded up to 16 bytes, the new stack
+		// pointer is the address of the allocation.
+		sp := b.Op(IRGetSP, "i32")
+		size := b.Op(IRAdd, "i32", b.Expr(node.Unary.Expr), b.Const("i32", 15))
+		size = b.Op(IRAnd, "i32", size, b.Const("i32", -16))
+		addr := b.Op(IRSub, "i32", sp, size)
+		b.Emit(&IRInstr{Op: IRSetSP, Args: []*IRReg{addr}})
+		return addr
+	case NKFuncCall:
+		return b.FuncCall(node)
+	case NKVaArg:
+		// Advance the va_list by one slot and load from its old value.
+		ap := b.Addr(node.Unary.Expr)
+		arg := b.Load(IntType, ap)
+		b.Store(IntType, ap, b.Op(IRAdd, "i32", arg, b.Const("i32", VaSlotSize)))
+		if node.Type.IsStructUnion() {
+			// The slot holds the address of the caller's copy.
+			return b.Load(IntType, arg)
+		}
+		return b.Load(node.Type, arg)
+	}
+
+	lhs := b.Expr(node.Binary.Lhs)
+	rhs := b.Expr(node.Binary.Rhs)
+	t := node.Type.WasmType()
+	switch node.Kind {
+	case NKAdd:
+		return b.Op(IRAdd, t, lhs, rhs)
+	case NKSub:
+		return b.Op(IRSub, t, lhs, rhs)
+	case NKMul:
+		return b.Op(IRMul, t, lhs, rhs)
+	case NKDiv:
+		return b.Op(IRDiv, t, lhs, rhs)
+	case NKEq:
+		return b.Op(IREq, "i32", lhs, rhs)
+	case NKNe:
+		return b.Op(IRNe, "i32", lhs, rhs)
+	case NKLt:
+		return b.Op(IRLt, "i32", lhs, rhs)
+	case NKLe:
+		return b.Op(IRLe, "i32", lhs, rhs)
+	}
+
+	panic(errors.New("invalid expression"))
+}
+
+func (b *irBuilder) FuncCall(node *Node) *IRReg {
+	call := node.FuncCall
+	var args []*IRReg
+	if call.RetBuf != nil {
+		args = append(args, b.Frame(call.RetBuf))
+	}
+	for _, arg := range call.Args {
+		args = append(args, b.Expr(arg))
+	}
+
+	if call.VarArea != nil {
+		// Spill the variadic arguments into the caller's frame and pass the
+		// address of the area.
+		for i, arg := range call.VarArgs {
+			slot := b.Op(IRAdd, "i32", b.Frame(call.VarArea), b.Const("i32", int64(i*VaSlotSize)))
+			val := b.Expr(arg)
+			b.Emit(&IRInstr{Op: IRStore, Args: []*IRReg{slot, val}, Size: wasmSize(val.Type)})
+		}
+		args = append(args, b.Frame(call.VarArea))
+	}
+
+	dst := b.fn.NewReg(node.Type.WasmType())
+	if call.Ptr == nil {
+		b.Emit(&IRInstr{Op: IRCall, Dst: dst, Args: args, Name: call.Name})
+		return dst
+	}
+	args = append(args, b.Expr(call.Ptr))
+	b.Emit(&IRInstr{Op: IRCallIndirect, Dst: dst, Args: args})
+	return dst
+}
+
+func (b *irBuilder) Addr(node *Node) *IRReg {
+	switch node.Kind {
+	case NKVariable, NKStringLiteral:
+		o := node.Variable.Object
+		switch o.Kind {
+		case OKLocal:
+			addr := b.Frame(o)
+			if o.Type.Kind == TYVLA {
+				return b.Load(IntType, addr)
+			}
+			return addr
+		case OKGlobal, OKStringLiteral:
+			dst := b.fn.NewReg("i32")
+			b.Emit(&IRInstr{Op: IRGlobal, Dst: dst, Object: o})
+			return dst
+		case OKFunction:
+			dst := b.fn.NewReg("i32")
+			b.Emit(&IRInstr{Op: IRFuncAddr, Dst: dst, Name: o.Name})
+			return dst
+		}
+	case NKDeRef:
+		return b.Expr(node.Unary.Expr)
+	case NKVLAPtr:
+		return b.Frame(node.Variable.Object)
+	case NKComma:
+		b.Expr(node.Binary.Lhs)
+		return b.Addr(node.Binary.Rhs)
+	case NKMember:
+		base := b.Addr(node.MemberAccess.Struct)
+		return b.Op(IRAdd, "i32", base, b.Const("i32", int64(node.MemberAccess.Member.Offset)))
+	case NKFuncCall, NKAssign, NKStmtsExpr, NKVaArg:
+		// Struct and union values are already represented by their address.
+		if node.Type.IsStructUnion() {
+			return b.Expr(node)
+		}
+	}
+
+	panic(errors.New("not a lvalue"))
+}
+
+// Frame returns the address of a local in the current frame.
+func (b *irBuilder) Frame(o *Object) *IRReg {
+	dst := b.fn.NewReg("i32")
+	b.Emit(&IRInstr{Op: IRFrame, Dst: dst, Object: o})
+	return dst
+}
+
+// Load returns the value at addr. Arrays, structs, unions and functions are
+// represented by their address.
+func (b *irBuilder) Load(t *Type, addr *IRReg) *IRReg {
+	if t.IsAggregate() {
+		return addr
+	}
+	dst := b.fn.NewReg(t.WasmType())
+	b.Emit(&IRInstr{Op: IRLoad, Dst: dst, Args: []*IRReg{addr}, Size: t.Size, Unsigned: t.Kind == TYBool})
+	return dst
+}
+
+func (b *irBuilder) Store(t *Type, addr *IRReg, val *IRReg) {
+	b.Emit(&IRInstr{Op: IRStore, Args: []*IRReg{addr, val}, Size: t.Size})
+}
+
+// LoadBitfield returns the value of the bit-field m, whose storage unit is at
+// addr, sign-extended unless it is a _Bool.
+func (b *irBuilder) LoadBitfield(m *StructMember, addr *IRReg) *IRReg {
+	wt := m.Type.WasmType()
+	bits := int64(wasmSize(wt) * 8)
+	shr := IRShr
+	if m.Type.Kind == TYBool {
+		shr = IRShrU
+	}
+	v := b.Load(m.Type, addr)
+	v = b.Op(IRShl, wt, v, b.Const(wt, bits-int64(m.BitOffset+m.BitWidth)))
+	return b.Op(shr, wt, v, b.Const(wt, bits-int64(m.BitWidth)))
+}
+
+// StoreBitfield assigns rhs to the bit-field lhs, leaving the other bits of
+// its storage unit unchanged. The value is the bit-field as read back.
+func (b *irBuilder) StoreBitfield(lhs *Node, rhs *Node) *IRReg {
+	m := lhs.MemberAccess.Member
+	wt := m.Type.WasmType()
+	mask := int64(1)<<uint(m.BitWidth) - 1
+	clear := ^(mask << uint(m.BitOffset))
+	if wt == "i32" {
+		mask, clear = int64(int32(mask)), int64(int32(clear))
+	}
+
+	addr := b.Addr(lhs)
+	old := b.Op(IRAnd, wt, b.Load(m.Type, addr), b.Const(wt, clear))
+	v := b.Convert(b.Expr(rhs), rhs.Type, m.Type)
+	v = b.Op(IRAnd, wt, v, b.Const(wt, mask))
+	v = b.Op(IRShl, wt, v, b.Const(wt, int64(m.BitOffset)))
+	b.Store(m.Type, addr, b.Op(IROr, wt, old, v))
+	return b.LoadBitfield(m, addr)
+}
+
+// Convert converts a value from one scalar type to another. Any nonzero
+// value converts to a _Bool as 1, and values converted to char or short are
+// truncated to their width.
+func (b *irBuilder) Convert(v *IRReg, from *Type, to *Type) *IRReg {
+	if to.Kind == TYBool {
+		if from.Kind != TYBool {
+			v = b.Op(IREqz, "i32", b.Op(IREqz, "i32", v))
+		}
+		return v
+	}
+
+	switch {
+	case from.WasmType() == "i32" && to.WasmType() == "i64":
+		v = b.Op(IRExtend, "i64", v)
+	case from.WasmType() == "i64" && to.WasmType() == "i32":
+		v = b.Op(IRWrap, "i32", v)
+	}
+
+	switch {
+	case to.Kind == TYChar && from.Kind != TYChar && from.Kind != TYBool:
+		v = b.Op(IRExtend8, "i32", v)
+	case to.Kind == TYShort && from.Size > to.Size:
+		v = b.Op(IRExtend16, "i32", v)
+	}
+	return v
+}
+
+// wasmSize returns the size in bytes of a wasm value type.
+func wasmSize(t string) int {
+	if t == "i64" {
+		return 8
+	}
+	return 4
+}
@@ -17,11 +17,16 @@ const (
 	NKMember                        // .
 	NKAddr                          // unary &
 	NKDeRef                         // unary *
+	NKCast                          // (type)
 	NKReturn                        // "return"
 	NKIf                            // "if"
 	NKFor                           // "for", "while"
 	NKBlock                         // { ... }
 	NKFuncCall                      // function call
+	NKVaArg                         // va_arg
+	NKAlloca                        // __builtin_alloca
+	NKVLAPtr                        // address of the pointer held by a VLA
+	NKMemZero                       // zero a local before initializing it
 	NKExprStmt                      // expression stmt
 	NKStmtsExpr                     // stmts expression
 	NKVariable                      // Variable
@@ -33,6 +38,13 @@ type StructMember struct {
 	Type   *Type
 	Name   string
 	Offset int
+	Align  int // alignment requested by _Alignas or the aligned attribute
+
+	// A bit-field occupies BitWidth bits starting at BitOffset within the
+	// storage unit of its type at Offset.
+	IsBitfield bool
+	BitOffset  int
+	BitWidth   int
 }
 
 type IfClause struct {
@@ -58,8 +70,19 @@ type Unary struct {
 }
 
 type FuncCall struct {
-	Name string
-	Args []*Node
+	Name     string
+	Ptr      *Node // callee of an indirect call, nil for direct calls
+	FuncType *Type // nil for implicitly declared functions
+	Args     []*Node
+
+	// Struct and union results are written to RetBuf, whose address is
+	// passed as a hidden first argument and returned by the callee.
+	RetBuf *Object
+
+	// Arguments matching the "..." of a variadic callee. They are spilled
+	// into VarArea, whose address is passed as a hidden last argument.
+	VarArgs []*Node
+	VarArea *Object
 }
 
 type MemberAccess struct {
@@ -69,6 +92,10 @@ type MemberAccess struct {
 
 type Block struct {
 	Stmts []*Node
+
+	// Set if the block allocates variable length arrays, the stack pointer
+	// is saved here on entry and restored on exit to free them.
+	SpSave *Object
 }
 
 type Variable struct {
@@ -115,7 +142,7 @@ func NewNode(kind NodeKind, val NodeVal, tok *Token) *Node {
 	switch kind {
 	case NKAdd, NKSub, NKMul, NKDiv, NKEq, NKNe, NKLt, NKLe, NKAssign, NKComma:
 		n.Binary = val.(*Binary)
-	case NKNeg, NKAddr, NKDeRef, NKReturn, NKExprStmt:
+	case NKNeg, NKAddr, NKDeRef, NKCast, NKReturn, NKExprStmt, NKVaArg, NKAlloca:
 		n.Unary = val.(*Unary)
 	case NKMember:
 		n.MemberAccess = val.(*MemberAccess)
@@ -129,13 +156,20 @@ func NewNode(kind NodeKind, val NodeVal, tok *Token) *Node {
 		n.FuncCall = val.(*FuncCall)
 	case NKNum:
 		n.Num = val.(*Number)
-	case NKVariable, NKStringLiteral:
+	case NKVariable, NKStringLiteral, NKVLAPtr, NKMemZero:
 		n.Variable = val.(*Variable)
 	}
 	n.addType()
 	return n
 }
 
+// NewCast converts the value of expr to type t.
+func NewCast(expr *Node, t *Type) *Node {
+	n := NewNode(NKCast, &Unary{Expr: expr}, expr.Tok)
+	n.Type = t
+	return n
+}
+
 func NewNodeAdd(lhs *Node, rhs *Node, tok *Token) *Node {
 	if lhs.Type.IsInteger() && rhs.Type.IsInteger() {
 		return NewNode(NKAdd, &Binary{Lhs: lhs, Rhs: rhs}, tok)
@@ -150,7 +184,7 @@ func NewNodeAdd(lhs *Node, rhs *Node, tok *Token) *Node {
 	}
 
 	rhs = NewNode(NKMul, &Binary{
-		Lhs: rhs,
+		Lhs: ptrOffset(rhs),
 		Rhs: NewNode(NKNum, &Number{Val: lhs.Type.Base.Size}, tok),
 	}, tok)
 
@@ -168,7 +202,7 @@ func NewNodeSub(lhs *Node, rhs *Node, tok *Token) *Node {
 
 	if lhs.Type.Base != nil && rhs.Type.IsInteger() {
 		rhs = NewNode(NKMul, &Binary{
-			Lhs: rhs,
+			Lhs: ptrOffset(rhs),
 			Rhs: NewNode(NKNum, &Number{Val: lhs.Type.Base.Size}, tok),
 		}, tok)
 
@@ -189,13 +223,45 @@ func NewNodeSub(lhs *Node, rhs *Node, tok *Token) *Node {
 	panic(tok.Errorf("invalid operands"))
 }
 
+// ptrOffset converts an integer added to a pointer to the width of
+// pointers.
+func ptrOffset(n *Node) *Node {
+	if n.Type.Kind == TYLong {
+		return NewCast(n, IntType)
+	}
+	return n
+}
+
+// commonType returns the type the operands of an arithmetic operator are
+// converted to. Types smaller than int are promoted to int.
+func commonType(t1 *Type, t2 *Type) *Type {
+	if t1.Kind == TYLong || t2.Kind == TYLong {
+		return LongType
+	}
+	return IntType
+}
+
+// usualArithConv converts both integer operands to their common type.
+func (b *Binary) usualArithConv() {
+	if !b.Lhs.Type.IsInteger() || !b.Rhs.Type.IsInteger() {
+		return
+	}
+	t := commonType(b.Lhs.Type, b.Rhs.Type)
+	if b.Lhs.Type.Kind != t.Kind {
+		b.Lhs = NewCast(b.Lhs, t)
+	}
+	if b.Rhs.Type.Kind != t.Kind {
+		b.Rhs = NewCast(b.Rhs, t)
+	}
+}
+
 func (n *Node) addType() {
 	if n.Type != nil {
 		return
 	}
 
 	switch n.Kind {
-	case NKNeg, NKAddr, NKDeRef, NKReturn, NKExprStmt:
+	case NKNeg, NKAddr, NKDeRef, NKCast, NKReturn, NKExprStmt:
 		if node := n.Unary.Expr; node != nil {
 			node.addType()
 		}
@@ -233,32 +299,71 @@ func (n *Node) addType() {
 	}
 
 	switch n.Kind {
-	case NKAdd, NKSub, NKMul, NKDiv, NKAssign:
+	case NKAdd, NKSub, NKMul, NKDiv:
+		n.Binary.usualArithConv()
 		n.Type = n.Binary.Lhs.Type
 		if n.Kind == NKSub &&
-			n.Binary.Lhs.Type.Kind == TYPtr &&
-			n.Binary.Rhs.Type.Kind == TYPtr {
+			n.Binary.Lhs.Type.Base != nil &&
+			n.Binary.Rhs.Type.Base != nil {
 			n.Type = IntType
 		}
+	case NKAssign:
+		// The value is converted to the type of a scalar lhs, which is also
+		// the type of the assignment.
+		n.Type = n.Binary.Lhs.Type
+		if !n.Type.IsAggregate() && n.Binary.Rhs.Type.Kind != n.Type.Kind {
+			if n.Binary.Rhs.Type.IsStructUnion() {
+				panic(n.Tok.Errorf("incompatible types when assigning"))
+			}
+			n.Binary.Rhs = NewCast(n.Binary.Rhs, n.Type)
+		}
 	case NKComma:
 		n.Binary.Lhs.addType()
 		n.Binary.Rhs.addType()
 		n.Type = n.Binary.Rhs.Type
 	case NKNeg:
 		n.Type = n.Unary.Expr.Type
-	case NKEq, NKNe, NKLt, NKLe, NKNum, NKFuncCall:
+		if n.Type.IsInteger() {
+			n.Type = commonType(n.Type, IntType)
+		}
+	case NKEq, NKNe, NKLt, NKLe:
+		n.Binary.usualArithConv()
+		n.Type = IntType
+	case NKMemZero:
 		n.Type = IntType
+	case NKNum:
+		n.Type = IntType
+		if n.Num.Val != int(int32(n.Num.Val)) {
+			n.Type = LongType
+		}
+	case NKFuncCall:
+		n.Type = IntType
+		if ft := n.FuncCall.FuncType; ft != nil {
+			n.Type = ft.Base
+		}
 	case NKVariable, NKStringLiteral:
 		n.Type = n.Variable.Object.Type
+	case NKVLAPtr:
+		n.Type = NewType(TYPtr, n.Variable.Object.Type.Base, nil)
+	case NKAlloca:
+		n.Type = NewType(TYPtr, CharType, nil)
 	case NKMember:
 		n.Type = n.MemberAccess.Member.Type
 	case NKAddr:
-		if n.Unary.Expr.Type.Kind == TYArray {
+		if e := n.Unary.Expr; e.Kind == NKMember && e.MemberAccess.Member.IsBitfield {
+			panic(n.Tok.Errorf("cannot take address of bit-field '%s'", e.MemberAccess.Member.Name))
+		}
+		if n.Unary.Expr.Type.Kind == TYArray || n.Unary.Expr.Type.Kind == TYVLA {
 			n.Type = NewType(TYPtr, n.Unary.Expr.Type.Base, nil)
 		} else {
 			n.Type = NewType(TYPtr, n.Unary.Expr.Type, nil)
 		}
 	case NKDeRef:
+		// Dereferencing a function designator yields the function itself.
+		if n.Unary.Expr.Type.Kind == TYFunc {
+			n.Type = n.Unary.Expr.Type
+			break
+		}
 		if n.Unary.Expr.Type.Base == nil {
 			panic(n.Tok.Errorf("invalid pointer dereference"))
 		}
@@ -5,16 +5,33 @@ type Local struct {
 }
 
 type Function struct {
-	Body         *Node
-	Params       []*Object
-	Locals       []*Object
-	IsDefinition bool
-	StackSize    int
+	Body        *Node
+	Params      []*Object
+	Locals      []*Object
+	IsPrototype bool // declared without a body
+
+	// A function which is referenced but never defined is imported from the
+	// host, as ImportName of module ImportModule.
+	IsReferenced bool
+	ImportModule string
+	ImportName   string
 }
 
 type Global struct {
+	Offset  int
+	Val     interface{}
+	IsLocal bool // a static local, which is never exported
+
+	// Addresses of other objects within the initial value.
+	Relocs []*Reloc
+}
+
+// Reloc is an address stored at Offset in the initial value of a global,
+// which is the address of Label plus Addend.
+type Reloc struct {
 	Offset int
-	Val    interface{}
+	Label  *Object
+	Addend int
 }
 
 type ObjectKind int
@@ -27,9 +44,15 @@ const (
 )
 
 type Object struct {
-	Name string
-	Kind ObjectKind
-	Type *Type
+	Name  string
+	Kind  ObjectKind
+	Type  *Type
+	Align int // alignment requested by _Alignas or the aligned attribute
+
+	// Static objects are only visible within the file, and are not exported
+	// by default. Export overrides the name exported under.
+	IsStatic bool
+	Export   string
 
 	// Only one of the following fields will be set.
 	Local    *Local
@@ -37,17 +60,11 @@ type Object struct {
 	Function *Function
 }
 
-func (o *Object) AlignLocals() *Object {
-	if o.Kind != OKFunction {
-		return o
-	}
-
-	offset := 0
-	for _, l := range o.Function.Locals {
-		offset += l.Type.Size
-		offset = alignTo(offset, l.Type.Align)
-		l.Local.Offset = offset
+// Alignment returns the alignment of the object, which is that of its type
+// unless a stricter one was requested.
+func (o *Object) Alignment() int {
+	if o.Align > o.Type.Align {
+		return o.Align
 	}
-	o.Function.StackSize = alignTo(offset, 16)
-	return o
+	return o.Type.Align
 }
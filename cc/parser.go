@@ -1,5 +1,11 @@
 package cc
 
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
 type Scope struct {
 	vars []*Object
 	tags []*Type
@@ -7,11 +13,20 @@ type Scope struct {
 
 type Parser struct {
 	tokens    []*Token
-	literals  []*Object
+	packs     []int     // "#pragma pack" value in effect at each token
+	literals  []*Object // compound literals and static locals
+	implicits []*Node   // calls to functions not declared before
 	scopes    []*Scope
+	locals    []*Object
+	fn        *Object
+	hasVLA    bool
 	stackSize int
 	pos       int
 	strId     int
+
+	// The depth of operands which are not evaluated, such as that of
+	// sizeof. Functions called there are not used by the program.
+	unevaluated int
 }
 
 func NewParser(tokens []*Token) *Parser {
@@ -34,19 +49,81 @@ func (p *Parser) Parse() (objects []*Object, err error) {
 		}
 	}()
 
+	p.Pragmas()
 	for !p.ReachedEOF() {
-		if p.IsFunction() {
-			objects = append(objects, p.FuncDef())
+		if p.IsStaticAssert() {
+			p.StaticAssert()
+			continue
+		}
+		base, attr := p.DeclSpec()
+		if p.Current().Equal(TKPunctuator, ";") {
+			p.Next()
+			continue
+		}
+		if p.IsFunction(base) {
+			objects = append(objects, p.FuncDef(base, attr))
 			continue
 		}
-		objects = append(objects, p.GlobalVariables()...)
+		objects = append(objects, p.GlobalVariables(base, attr)...)
 	}
 
+	objects = append(objects, p.ResolveImplicitCalls()...)
 	objects = append(objects, p.literals...)
 
 	return
 }
 
+// Pragmas removes "#pragma" lines from the tokens, recording the value of
+// "#pragma pack" in effect at each remaining token. Other pragmas are ignored.
+func (p *Parser) Pragmas() {
+	tokens := make([]*Token, 0, len(p.tokens))
+	stack := []int{0}
+	for _, tok := range p.tokens {
+		if tok.Kind != TKPragma {
+			tokens = append(tokens, tok)
+			p.packs = append(p.packs, stack[len(stack)-1])
+			continue
+		}
+
+		args := NewParser(tok.Val.([]*Token))
+		if !args.Current().Equal(TKIdentifier, "pack") {
+			continue
+		}
+		args.Next()
+		args.Consume(TKPunctuator, "(")
+		switch {
+		case args.Current().Equal(TKIdentifier, "push"):
+			args.Next()
+			stack = append(stack, stack[len(stack)-1])
+			if args.Current().Equal(TKPunctuator, ",") {
+				args.Next()
+				stack[len(stack)-1] = args.PackValue()
+			}
+		case args.Current().Equal(TKIdentifier, "pop"):
+			args.Next()
+			if len(stack) > 1 {
+				stack = stack[:len(stack)-1]
+			}
+		case args.Current().Equal(TKPunctuator, ")"):
+			stack[len(stack)-1] = 0
+		default:
+			stack[len(stack)-1] = args.PackValue()
+		}
+		args.Consume(TKPunctuator, ")")
+	}
+	p.tokens = tokens
+}
+
+// PackValue parses the alignment argument of "#pragma pack".
+func (p *Parser) PackValue() int {
+	tok := p.Current()
+	n := p.ConstExpr()
+	if n != 1 && n != 2 && n != 4 && n != 8 && n != 16 {
+		panic(tok.Errorf("alignment must be a small power of two, not %d", n))
+	}
+	return n
+}
+
 func (p *Parser) EnterScope() {
 	p.scopes = append([]*Scope{{}}, p.scopes...)
 }
@@ -72,9 +149,18 @@ func (p *Parser) AddLocals(locals ...*Object) {
 		l.Kind = OKLocal
 		l.Local = &Local{}
 		p.PushVarScope(l)
+		p.locals = append(p.locals, l)
 	}
 }
 
+// AddHiddenLocal allocates an unnamed local in the current function, e.g.
+// for the spilled arguments of a variadic call.
+func (p *Parser) AddHiddenLocal(t *Type) *Object {
+	l := &Object{Kind: OKLocal, Type: t, Local: &Local{}}
+	p.locals = append(p.locals, l)
+	return l
+}
+
 func (p *Parser) AddGlobals(globals ...*Object) {
 	for _, g := range globals {
 		g.Kind = OKGlobal
@@ -83,6 +169,11 @@ func (p *Parser) AddGlobals(globals ...*Object) {
 	}
 }
 
+func (p *Parser) AddFunction(f *Object) {
+	global := p.scopes[len(p.scopes)-1]
+	global.vars = append(global.vars, f)
+}
+
 func (p *Parser) ReachedEOF() bool {
 	if p.Current().Equal(TKEof, "") {
 		return true
@@ -125,64 +216,304 @@ func (p *Parser) FindVariable(name string) *Object {
 
 func (p *Parser) FindTags(name string) *Type {
 	for _, s := range p.scopes {
-		for _, t := range s.tags {
-			if t.Val.(*StructVal).Name != nil && name == t.Val.(*StructVal).Name.Val.(string) {
-				return t
-			}
+		if t := s.FindTag(name); t != nil {
+			return t
+		}
+	}
+	return nil
+}
+
+func (s *Scope) FindTag(name string) *Type {
+	for _, t := range s.tags {
+		if t.Val.(*StructVal).Name != nil && name == t.Val.(*StructVal).Name.Val.(string) {
+			return t
 		}
 	}
 	return nil
 }
 
-func (p *Parser) IsFunction() bool {
-	pos := p.pos
-	o, _ := p.Declarator(p.DeclSpec())
+func (p *Parser) IsFunction(base *Type) bool {
+	pos, tags := p.pos, p.scopes[0].tags
+	o, _ := p.Declarator(base)
 	p.MoveTo(pos)
+	p.scopes[0].tags = tags
 	return o.Type.Kind == TYFunc
 }
 
-func (p *Parser) GlobalVariables() []*Object {
-	base := p.DeclSpec()
+// CheckComplete rejects objects of incomplete type, which have no size.
+// Unless allowVLA is set, variable length arrays are rejected as well.
+func (p *Parser) CheckComplete(tok *Token, o *Object, allowVLA bool) {
+	if o.Type.IsIncomplete() {
+		panic(tok.Errorf("'%s' has incomplete type", o.Name))
+	}
+	if o.Type.Kind == TYVLA && !allowVLA {
+		panic(tok.Errorf("'%s' cannot be a variable length array", o.Name))
+	}
+}
+
+func (p *Parser) GlobalVariables(base *Type, attr *DeclAttr) []*Object {
+	globals := make([]*Object, 0)
 	first := true
 	for !p.Current().Equal(TKPunctuator, ";") {
 		if !first {
 			p.Consume(TKPunctuator, ",")
 		}
 		first = false
+		tok := p.Current()
 		o, _ := p.Declarator(base)
+		a := p.DeclaratorAttributes(attr)
+		o.Align, o.IsStatic, o.Export = a.Align, a.IsStatic, a.ExportName
 		p.AddGlobals(o)
+		if p.Current().Equal(TKPunctuator, "=") {
+			p.Next()
+			init, t := p.Initializer(o.Type)
+			o.Type = t
+			p.GlobalData(o, init)
+		}
+		p.CheckComplete(tok, o, false)
+		globals = append(globals, o)
 	}
 	p.Next()
 
-	return p.ScopeVars()
+	return globals
 }
 
-func (p *Parser) FuncDef() *Object {
+func (p *Parser) FuncDef(base *Type, attr *DeclAttr) *Object {
 	p.EnterScope()
-	o, params := p.Declarator(p.DeclSpec())
-	f := &Function{}
+	tok := p.Current()
+	o, params := p.Declarator(base)
+	attr = p.DeclaratorAttributes(attr)
+	if o.Type.Base.IsStructUnion() {
+		params = append([]*Object{{Name: RetBufName, Type: NewType(TYPtr, o.Type.Base, nil)}}, params...)
+	}
+	if o.Type.Val.(*FuncVal).IsVariadic {
+		params = append(params, &Object{Name: VaAreaName, Type: VaListType})
+	}
+	if prev := p.FindVariable(o.Name); prev != nil {
+		switch {
+		case prev.Kind != OKFunction || !prev.Type.IsCompatible(o.Type):
+			panic(tok.Errorf("conflicting types for '%s'", o.Name))
+		case !prev.Function.IsPrototype && p.Current().Equal(TKPunctuator, "{"):
+			panic(tok.Errorf("redefinition of '%s'", o.Name))
+		case !prev.IsStatic && attr.IsStatic:
+			panic(tok.Errorf("static declaration of '%s' follows non-static declaration", o.Name))
+		}
+		// A function declared static stays static when redeclared.
+		attr.IsStatic = prev.IsStatic
+	}
+	if p.Current().Equal(TKPunctuator, "{") {
+		// A definition with empty parentheses takes no arguments.
+		o.Type.Val.(*FuncVal).NoPrototype = false
+	}
+
+	f := &Function{
+		Params:       params,
+		ImportModule: attr.ImportModule,
+		ImportName:   attr.ImportName,
+	}
+	fn := &Object{
+		Name:     o.Name,
+		Kind:     OKFunction,
+		Type:     o.Type,
+		IsStatic: attr.IsStatic,
+		Export:   attr.ExportName,
+		Function: f,
+	}
+	p.AddFunction(fn)
+	p.fn = fn
+	p.locals = nil
 
 	if p.Current().Equal(TKPunctuator, ";") {
 		p.Consume(TKPunctuator, ";")
-		f.IsDefinition = true
+		f.IsPrototype = true
 	} else {
 		p.Consume(TKPunctuator, "{")
+		for _, param := range params {
+			if param.Name == "" {
+				panic(p.Current().Errorf("parameter name omitted in '%s'", o.Name))
+			}
+			p.CheckComplete(p.Current(), param, false)
+		}
 		p.AddLocals(params...)
 
 		f.Body = p.Stmts()
-		f.Locals = p.ScopeVars()
+		f.Locals = p.locals
 	}
 
-	f.Params = params
+	p.fn = nil
 	p.LeaveScope()
-	return (&Object{
-		Name:     o.Name,
-		Kind:     OKFunction,
-		Function: f,
-	}).AlignLocals()
+	return fn
+}
+
+// DeclAttr holds the alignment specifiers and attributes of a declaration.
+type DeclAttr struct {
+	Align  int
+	Packed bool
+
+	// Set by the import_module and import_name attributes of functions.
+	ImportModule string
+	ImportName   string
+	ExportName   string // set by the export_name attribute
+
+	IsStatic bool // the "static" storage class specifier
+}
+
+// DeclSpec parses a type specifier along with any storage class, alignment
+// specifiers and attributes around it.
+func (p *Parser) DeclSpec() (*Type, *DeclAttr) {
+	attr := &DeclAttr{}
+	p.StorageClass(attr)
+	t := p.TypeSpec()
+	p.StorageClass(attr)
+	return t, attr
+}
+
+// StorageClass parses "static" along with any attributes around it.
+func (p *Parser) StorageClass(attr *DeclAttr) {
+	for {
+		p.Attributes(attr)
+		if !p.Current().Equal(TKKeyword, "static") {
+			return
+		}
+		p.Next()
+		attr.IsStatic = true
+	}
+}
+
+// NoStorageClass rejects a storage class where only a type may appear.
+func NoStorageClass(attr *DeclAttr, tok *Token, where string) {
+	if attr.IsStatic {
+		panic(tok.Errorf("storage class specified for %s", where))
+	}
+}
+
+// DeclaratorAttributes returns the attributes of a single declarator, which
+// are those of its declaration plus any following the declarator.
+func (p *Parser) DeclaratorAttributes(attr *DeclAttr) *DeclAttr {
+	a := *attr
+	p.Attributes(&a)
+	return &a
+}
+
+// Attributes parses any number of "_Alignas(...)" specifiers and
+// "__attribute__((...))" lists into attr.
+func (p *Parser) Attributes(attr *DeclAttr) {
+	for {
+		tok := p.Current()
+		switch {
+		case tok.Equal(TKKeyword, "_Alignas"):
+			p.Next()
+			p.Consume(TKPunctuator, "(")
+			if p.IsTypeName() {
+				attr.Align = int(math.Max(float64(attr.Align), float64(p.TypeName().Align)))
+			} else {
+				attr.Align = int(math.Max(float64(attr.Align), float64(p.AlignValue())))
+			}
+			p.Consume(TKPunctuator, ")")
+		case tok.Equal(TKKeyword, "__attribute__"):
+			p.Next()
+			p.Consume(TKPunctuator, "(")
+			p.Consume(TKPunctuator, "(")
+			first := true
+			for !p.Current().Equal(TKPunctuator, ")") {
+				if !first {
+					p.Consume(TKPunctuator, ",")
+				}
+				first = false
+				p.Attribute(attr)
+			}
+			p.Consume(TKPunctuator, ")")
+			p.Consume(TKPunctuator, ")")
+		default:
+			return
+		}
+	}
+}
+
+// Attribute parses a single attribute. Unknown attributes are ignored along
+// with their arguments.
+func (p *Parser) Attribute(attr *DeclAttr) {
+	tok := p.Current()
+	if tok.Kind != TKIdentifier && tok.Kind != TKKeyword {
+		panic(tok.Errorf("expected an attribute name, got '%s' instead", tok.Lexeme))
+	}
+	p.Next()
+
+	switch strings.Trim(tok.Lexeme, "_") {
+	case "packed":
+		attr.Packed = true
+	case "aligned":
+		// Without an argument the alignment is the largest one used by any
+		// type on the target.
+		align := 16
+		if p.Current().Equal(TKPunctuator, "(") {
+			p.Next()
+			align = p.AlignValue()
+			p.Consume(TKPunctuator, ")")
+		}
+		attr.Align = int(math.Max(float64(attr.Align), float64(align)))
+	case "import_module":
+		attr.ImportModule = p.AttributeString()
+	case "import_name":
+		attr.ImportName = p.AttributeString()
+	case "export_name":
+		attr.ExportName = p.AttributeString()
+	default:
+		if p.Current().Equal(TKPunctuator, "(") {
+			p.SkipParens()
+		}
+	}
 }
 
-func (p *Parser) DeclSpec() *Type {
+// SkipParens skips a parenthesized token sequence.
+func (p *Parser) SkipParens() {
+	tok := p.Current()
+	depth := 0
+	for {
+		switch {
+		case p.ReachedEOF():
+			panic(tok.Errorf("unbalanced parentheses"))
+		case p.Current().Equal(TKPunctuator, "("):
+			depth++
+		case p.Current().Equal(TKPunctuator, ")"):
+			depth--
+		}
+		p.Next()
+		if depth == 0 {
+			return
+		}
+	}
+}
+
+// AttributeString parses the string argument of an attribute.
+func (p *Parser) AttributeString() string {
+	p.Consume(TKPunctuator, "(")
+	tok := p.Current()
+	if tok.Kind != TKString {
+		panic(tok.Errorf("expected a string literal, got '%s' instead", tok.Lexeme))
+	}
+	p.Next()
+	p.Consume(TKPunctuator, ")")
+	return string(tok.Val.(*String).Val)
+}
+
+// AlignValue parses an alignment, which must be zero or a power of two.
+// Zero leaves the alignment unchanged.
+func (p *Parser) AlignValue() int {
+	tok := p.Current()
+	n := p.ConstExpr()
+	if n < 0 || n&(n-1) != 0 {
+		panic(tok.Errorf("requested alignment %d is not a power of two", n))
+	}
+	return n
+}
+
+// TypeSpec parses a type specifier.
+func (p *Parser) TypeSpec() *Type {
+	if p.IsVaList() {
+		p.Next()
+		return VaListType
+	}
 	if p.Current().Equal(TKKeyword, "long") {
 		p.Consume(TKKeyword, "long")
 		return LongType
@@ -199,6 +530,10 @@ func (p *Parser) DeclSpec() *Type {
 		p.Consume(TKKeyword, "char")
 		return CharType
 	}
+	if p.Current().Equal(TKKeyword, "_Bool") || p.IsBool() {
+		p.Next()
+		return BoolType
+	}
 
 	if p.Current().Equal(TKKeyword, "struct") {
 		p.Consume(TKKeyword, "struct")
@@ -211,7 +546,7 @@ func (p *Parser) DeclSpec() *Type {
 	panic(p.Current().Errorf("type name expected"))
 }
 
-func (p *Parser) FuncParams() []*Object {
+func (p *Parser) FuncParams() ([]*Object, bool) {
 	params := make([]*Object, 0)
 	first := true
 	for !p.Current().Equal(TKPunctuator, ")") {
@@ -219,33 +554,85 @@ func (p *Parser) FuncParams() []*Object {
 			p.Consume(TKPunctuator, ",")
 		}
 		first = false
-		o, _ := p.Declarator(p.DeclSpec())
+
+		if p.Current().Equal(TKPunctuator, "...") {
+			p.Next()
+			p.Consume(TKPunctuator, ")")
+			return params, true
+		}
+
+		tok := p.Current()
+		base, attr := p.DeclSpec()
+		NoStorageClass(attr, tok, "parameter")
+		o, _ := p.Declarator(base)
+
+		// Parameters of array and function type are adjusted to pointers.
+		switch o.Type.Kind {
+		case TYArray:
+			o.Type = NewType(TYPtr, o.Type.Base, nil)
+		case TYFunc:
+			o.Type = NewType(TYPtr, o.Type, nil)
+		}
 		params = append(params, o)
 	}
 	p.Next()
-	return params
+	return params, false
 }
 
 func (p *Parser) TypeSuffix(base *Type) (*Type, []*Object) {
 	if p.Current().Equal(TKPunctuator, "(") {
 		p.Next()
-		return NewType(TYFunc, base, nil), p.FuncParams()
+		params, variadic := p.FuncParams()
+		types := make([]*Type, 0, len(params))
+		for _, param := range params {
+			types = append(types, param.Type)
+		}
+		return NewType(TYFunc, base, &FuncVal{
+			Params:      types,
+			IsVariadic:  variadic,
+			NoPrototype: len(params) == 0 && !variadic,
+		}), params
 	}
 	if p.Current().Equal(TKPunctuator, "[") {
 		p.Next()
 
 		tok := p.Current()
-		if tok.Kind != TKNumber {
-			panic(tok.Errorf("expected a number, got '%s' instead", tok.Lexeme))
+		if tok.Equal(TKPunctuator, "]") {
+			p.Next()
+			t, _ := p.TypeSuffix(base)
+			p.CheckElementType(tok, t)
+			return NewType(TYArray, t, -1), nil
 		}
-		p.Next()
+		// A length which isn't a constant expression makes a variable
+		// length array.
+		n := p.Assign()
 		p.Consume(TKPunctuator, "]")
 		t, _ := p.TypeSuffix(base)
-		return NewType(TYArray, t, tok.Val), nil
+		p.CheckElementType(tok, t)
+		length, ok := tryEval(n)
+		if !ok {
+			return NewType(TYVLA, t, &VLAVal{Len: n}), nil
+		}
+		if length < 0 {
+			panic(tok.Errorf("size of array is negative"))
+		}
+		return NewType(TYArray, t, length), nil
 	}
 	return base, nil
 }
 
+// CheckElementType checks the element type t of an array. Elements have a
+// constant size, so only the outermost dimension of an array may have a
+// variable length: int a[n][3] is accepted, but not int a[3][n] or a[n][n].
+func (p *Parser) CheckElementType(tok *Token, t *Type) {
+	if t.IsIncomplete() {
+		panic(tok.Errorf("array has incomplete element type"))
+	}
+	if t.Kind == TYVLA {
+		panic(tok.Errorf("arrays of variable length arrays are not supported"))
+	}
+}
+
 func (p *Parser) Declarator(base *Type) (*Object, []*Object) {
 	for p.Current().Equal(TKPunctuator, "*") {
 		p.Next()
@@ -273,6 +660,16 @@ func (p *Parser) Declarator(base *Type) (*Object, []*Object) {
 		return nestedType, params
 	}
 
+	// Names may be omitted in abstract declarators such as the parameters
+	// of a prototype, e.g. "int (*)(int, int)", and in unnamed bit-fields.
+	if tok.Equal(TKPunctuator, ")") || tok.Equal(TKPunctuator, ",") || tok.Equal(TKPunctuator, ":") {
+		return &Object{Type: base}, nil
+	}
+	if tok.Equal(TKPunctuator, "[") {
+		t, _ := p.TypeSuffix(base)
+		return &Object{Type: t}, nil
+	}
+
 	if tok.Kind != TKIdentifier {
 		panic(tok.Errorf("expected a variable name, got '%s' instead", tok.Lexeme))
 	}
@@ -282,8 +679,20 @@ func (p *Parser) Declarator(base *Type) (*Object, []*Object) {
 	return &Object{Name: tok.Val.(string), Type: t}, params
 }
 
+// TypeName parses a type without a declared name, as in "va_arg(ap, int *)".
+func (p *Parser) TypeName() *Type {
+	tok := p.Current()
+	base, attr := p.DeclSpec()
+	NoStorageClass(attr, tok, "type name")
+	o, _ := p.Declarator(base)
+	if o.Name != "" {
+		panic(tok.Errorf("expected a type name"))
+	}
+	return o.Type
+}
+
 func (p *Parser) Declaration() *Node {
-	base := p.DeclSpec()
+	base, attr := p.DeclSpec()
 
 	first := true
 	assigns := make([]*Node, 0)
@@ -293,24 +702,75 @@ func (p *Parser) Declaration() *Node {
 		}
 		first = false
 
+		tok := p.Current()
 		obj, _ := p.Declarator(base)
+		obj.Align = p.DeclaratorAttributes(attr).Align
+		if attr.IsStatic {
+			p.StaticLocal(obj, tok)
+			continue
+		}
 		p.AddLocals(obj)
 
-		tok := p.Current()
-		if !tok.Equal(TKPunctuator, "=") {
-			continue
+		if eq := p.Current(); eq.Equal(TKPunctuator, "=") {
+			if obj.Type.Kind == TYVLA {
+				panic(eq.Errorf("variable-sized object may not be initialized"))
+			}
+			p.Next()
+			init, t := p.Initializer(obj.Type)
+			obj.Type = t
+			assigns = append(assigns, NewNode(NKExprStmt, &Unary{Expr: p.LocalInit(obj, init, eq)}, eq))
 		}
-		p.Next()
 
-		assigns = append(assigns, NewNode(NKExprStmt, &Unary{
-			Expr: NewNode(NKAssign,
-				&Binary{Lhs: NewNode(NKVariable, &Variable{Object: obj}, tok), Rhs: p.Assign()},
-				tok)}, tok))
+		p.CheckComplete(tok, obj, true)
+		if obj.Type.Kind == TYVLA {
+			assigns = append(assigns, p.AllocVLA(obj, tok)...)
+		}
 	}
 
 	return NewNode(NKBlock, &Block{Stmts: assigns}, p.Current())
 }
 
+// StaticLocal allocates the static local o in memory, like a global which is
+// only visible in its block. Its initializer must be constant.
+func (p *Parser) StaticLocal(o *Object, tok *Token) {
+	o.Kind = OKGlobal
+	o.Global = &Global{IsLocal: true}
+	o.IsStatic = true
+	p.PushVarScope(o)
+	if p.Current().Equal(TKPunctuator, "=") {
+		p.Next()
+		init, t := p.Initializer(o.Type)
+		o.Type = t
+		p.GlobalData(o, init)
+	}
+	if o.Type.Kind == TYVLA {
+		panic(tok.Errorf("storage size of '%s' isn't constant", o.Name))
+	}
+	p.CheckComplete(tok, o, false)
+	p.literals = append(p.literals, o)
+}
+
+// AllocVLA computes the size of the variable length array o and allocates
+// its elements on the stack.
+func (p *Parser) AllocVLA(o *Object, tok *Token) []*Node {
+	vla := o.Type.Val.(*VLAVal)
+	vla.Size = p.AddHiddenLocal(IntType)
+	p.hasVLA = true
+
+	size := NewNode(NKAssign, &Binary{
+		Lhs: NewNode(NKVariable, &Variable{Object: vla.Size}, tok),
+		Rhs: NewNode(NKMul, &Binary{Lhs: vla.Len, Rhs: NewNode(NKNum, &Number{Val: o.Type.Base.Size}, tok)}, tok),
+	}, tok)
+	alloc := NewNode(NKAssign, &Binary{
+		Lhs: NewNode(NKVLAPtr, &Variable{Object: o}, tok),
+		Rhs: NewNode(NKAlloca, &Unary{Expr: NewNode(NKVariable, &Variable{Object: vla.Size}, tok)}, tok),
+	}, tok)
+	return []*Node{
+		NewNode(NKExprStmt, &Unary{Expr: size}, tok),
+		NewNode(NKExprStmt, &Unary{Expr: alloc}, tok),
+	}
+}
+
 func (p *Parser) Stmt() *Node {
 	cur := p.Current()
 	if cur.Equal(TKKeyword, "return") {
@@ -318,6 +778,27 @@ func (p *Parser) Stmt() *Node {
 
 		expr := p.Expr()
 		p.Consume(TKPunctuator, ";")
+
+		if ret := p.fn.Type.Base; ret.IsStructUnion() {
+			// Copy the result to the caller's buffer and return its address.
+			if expr.Type.Val != ret.Val {
+				panic(cur.Errorf("incompatible types when returning '%s'", expr.Tok.Lexeme))
+			}
+			buf := p.FindVariable(RetBufName)
+			expr = NewNode(NKComma, &Binary{
+				Lhs: NewNode(NKAssign, &Binary{
+					Lhs: NewNode(NKDeRef, &Unary{Expr: NewNode(NKVariable, &Variable{Object: buf}, cur)}, cur),
+					Rhs: expr,
+				}, cur),
+				Rhs: NewNode(NKVariable, &Variable{Object: buf}, cur),
+			}, cur)
+		} else if expr.Type.Kind != ret.Kind {
+			// The value is converted to the return type of the function.
+			if expr.Type.IsStructUnion() {
+				panic(cur.Errorf("incompatible types when returning '%s'", expr.Tok.Lexeme))
+			}
+			expr = NewCast(expr, ret)
+		}
 		return NewNode(NKReturn, &Unary{Expr: expr}, cur)
 	}
 
@@ -377,21 +858,85 @@ func (p *Parser) Stmt() *Node {
 	return p.ExprStmt()
 }
 
+// IsVaList reports whether the current token names the va_list type. There is
+// no preprocessor to provide <stdarg.h>, so its names are builtin unless a
+// variable shadows them.
+func (p *Parser) IsVaList() bool {
+	tok := p.Current()
+	if tok.Kind != TKIdentifier || (tok.Lexeme != "va_list" && tok.Lexeme != "__builtin_va_list") {
+		return false
+	}
+	return p.FindVariable(tok.Lexeme) == nil
+}
+
+// IsBool reports whether the current token is bool, which <stdbool.h>
+// defines as _Bool. Like va_list, it is an identifier which a variable may
+// shadow.
+func (p *Parser) IsBool() bool {
+	tok := p.Current()
+	return tok.Kind == TKIdentifier && tok.Lexeme == "bool" && p.FindVariable(tok.Lexeme) == nil
+}
+
 func (p *Parser) IsTypeName() bool {
 	tok := p.Current()
-	return tok.Equal(TKKeyword, "long") ||
+	return p.IsVaList() ||
+		tok.Equal(TKKeyword, "long") ||
 		tok.Equal(TKKeyword, "int") ||
 		tok.Equal(TKKeyword, "short") ||
 		tok.Equal(TKKeyword, "char") ||
+		tok.Equal(TKKeyword, "_Bool") ||
+		p.IsBool() ||
+		tok.Equal(TKKeyword, "_Alignas") ||
+		tok.Equal(TKKeyword, "__attribute__") ||
 		tok.Equal(TKKeyword, "struct") ||
 		tok.Equal(TKKeyword, "union")
 }
 
+// IsStaticAssert reports whether a declaration starts with _Static_assert,
+// or its C23 spelling static_assert, which is an identifier like bool.
+func (p *Parser) IsStaticAssert() bool {
+	tok := p.Current()
+	return tok.Equal(TKKeyword, "_Static_assert") ||
+		tok.Kind == TKIdentifier && tok.Lexeme == "static_assert" && p.FindVariable(tok.Lexeme) == nil
+}
+
+// StaticAssert checks a "_Static_assert(expr, "msg");" declaration. The
+// message may be omitted.
+func (p *Parser) StaticAssert() {
+	p.Next()
+	p.Consume(TKPunctuator, "(")
+	tok := p.Current()
+	ok := p.ConstExpr() != 0
+
+	msg := ""
+	if p.Current().Equal(TKPunctuator, ",") {
+		p.Next()
+		if p.Current().Kind != TKString {
+			panic(p.Current().Errorf("expected a string literal, got '%s' instead", p.Current().Lexeme))
+		}
+		msg = p.Current().Lexeme
+		p.Next()
+	}
+	p.Consume(TKPunctuator, ")")
+	p.Consume(TKPunctuator, ";")
+
+	if !ok && msg == "" {
+		panic(tok.Errorf("static assertion failed"))
+	}
+	if !ok {
+		panic(tok.Errorf("static assertion failed: %s", msg))
+	}
+}
+
 func (p *Parser) Stmts() *Node {
 	var body []*Node
 	tok := p.Current()
+	hasVLA := p.hasVLA
+	p.hasVLA = false
 	for !p.Current().Equal(TKPunctuator, "}") {
-		if p.IsTypeName() {
+		if p.IsStaticAssert() {
+			p.StaticAssert()
+		} else if p.IsTypeName() || p.Current().Equal(TKKeyword, "static") {
 			body = append(body, p.Declaration())
 		} else {
 			body = append(body, p.Stmt())
@@ -400,7 +945,12 @@ func (p *Parser) Stmts() *Node {
 
 	p.Next()
 
-	return NewNode(NKBlock, &Block{Stmts: body}, tok)
+	block := &Block{Stmts: body}
+	if p.hasVLA {
+		block.SpSave = p.AddHiddenLocal(IntType)
+	}
+	p.hasVLA = hasVLA
+	return NewNode(NKBlock, block, tok)
 }
 
 func (p *Parser) ExprStmt() *Node {
@@ -559,13 +1109,89 @@ func (p *Parser) Unary() *Node {
 		return NewNode(NKAddr, &Unary{Expr: p.Unary()}, tok)
 	}
 
+	if tok.Equal(TKPunctuator, "(") {
+		p.Next()
+		if p.IsTypeName() {
+			return p.Cast(tok)
+		}
+		p.MoveTo(p.pos - 1)
+	}
+
 	return p.Postfix()
 }
 
-func (p *Parser) StructMembers() []*StructMember {
+// Cast parses the rest of a cast expression or compound literal after its
+// "(".
+func (p *Parser) Cast(tok *Token) *Node {
+	t := p.TypeName()
+	p.Consume(TKPunctuator, ")")
+	if p.Current().Equal(TKPunctuator, "{") {
+		return p.PostfixOps(p.CompoundLiteral(t, tok))
+	}
+	expr := p.Unary()
+	if t.IsAggregate() || expr.Type.IsStructUnion() {
+		panic(tok.Errorf("invalid cast"))
+	}
+	return NewCast(expr, t)
+}
+
+// CompoundLiteral parses the initializer list of "(type){...}", which is
+// an unnamed object. At file scope it is static data, in a function it is a
+// hidden local initialized every time the expression is evaluated.
+func (p *Parser) CompoundLiteral(t *Type, tok *Token) *Node {
+	if t.Kind == TYVLA {
+		panic(tok.Errorf("compound literal has variable size"))
+	}
+	init, t := p.Initializer(t)
+	if t.IsIncomplete() || t.Kind == TYFunc {
+		panic(tok.Errorf("compound literal has incomplete type"))
+	}
+
+	if p.fn == nil {
+		o := &Object{Kind: OKGlobal, Type: t, Global: &Global{}}
+		p.GlobalData(o, init)
+		p.literals = append(p.literals, o)
+		return NewNode(NKVariable, &Variable{Object: o}, tok)
+	}
+
+	o := p.AddHiddenLocal(t)
+	return NewNode(NKComma, &Binary{
+		Lhs: p.LocalInit(o, init, tok),
+		Rhs: NewNode(NKVariable, &Variable{Object: o}, tok),
+	}, tok)
+}
+
+func (p *Parser) StructMembers(kind TypeKind) []*StructMember {
 	ms := make([]*StructMember, 0)
+	var flexible *Token
+	// The members of anonymous members share the names of the outer ones.
+	names := make(map[string]bool)
+	declare := func(name string, tok *Token) {
+		if names[name] {
+			panic(tok.Errorf("duplicate member '%s'", name))
+		}
+		names[name] = true
+	}
 	for !p.Current().Equal(TKPunctuator, "}") {
-		base := p.DeclSpec()
+		if p.IsStaticAssert() {
+			p.StaticAssert()
+			continue
+		}
+		tok := p.Current()
+		base, attr := p.DeclSpec()
+		NoStorageClass(attr, tok, "member")
+
+		// A struct or union without a tag or declarator is an anonymous
+		// member, whose members are accessed as members of the outer one.
+		if p.Current().Equal(TKPunctuator, ";") && base.IsStructUnion() && base.Val.(*StructVal).Name == nil {
+			if flexible != nil {
+				panic(flexible.Errorf("flexible array member '%s' not at end of struct", flexible.Lexeme))
+			}
+			for _, name := range base.MemberNames() {
+				declare(name, tok)
+			}
+			ms = append(ms, &StructMember{Type: base, Align: attr.Align})
+		}
 
 		first := true
 		for !p.Current().Equal(TKPunctuator, ";") {
@@ -573,8 +1199,35 @@ func (p *Parser) StructMembers() []*StructMember {
 				p.Consume(TKPunctuator, ",")
 			}
 
+			tok := p.Current()
+			if flexible != nil {
+				panic(flexible.Errorf("flexible array member '%s' not at end of struct", flexible.Lexeme))
+			}
 			o, _ := p.Declarator(base)
-			ms = append(ms, &StructMember{Type: o.Type, Name: o.Name})
+			if o.Type.IsUnsizedArray() {
+				// A flexible array member takes no space, the struct is
+				// expected to be allocated with room for its elements.
+				switch {
+				case kind == TYUnion:
+					panic(tok.Errorf("flexible array member '%s' in union", o.Name))
+				case len(ms) == 0:
+					panic(tok.Errorf("flexible array member '%s' in a struct with no named members", o.Name))
+				}
+				flexible = tok
+			} else {
+				p.CheckComplete(tok, o, false)
+			}
+			m := &StructMember{Type: o.Type, Name: o.Name}
+			if p.Current().Equal(TKPunctuator, ":") {
+				p.BitfieldWidth(m)
+			} else if m.Name == "" {
+				panic(p.Current().Errorf("expected a member name, got '%s' instead", p.Current().Lexeme))
+			}
+			if m.Name != "" {
+				declare(m.Name, tok)
+			}
+			m.Align = p.DeclaratorAttributes(attr).Align
+			ms = append(ms, m)
 			first = false
 		}
 
@@ -585,39 +1238,96 @@ func (p *Parser) StructMembers() []*StructMember {
 	return ms
 }
 
+func (p *Parser) BitfieldWidth(m *StructMember) {
+	p.Consume(TKPunctuator, ":")
+	tok := p.Current()
+	m.IsBitfield = true
+	m.BitWidth = p.ConstExpr()
+	switch {
+	case m.BitWidth < 0:
+		panic(tok.Errorf("negative width in bit-field '%s'", m.Name))
+	case !m.Type.IsInteger():
+		panic(tok.Errorf("bit-field '%s' has invalid type", m.Name))
+	case m.BitWidth > m.Type.Size*8:
+		panic(tok.Errorf("width of bit-field '%s' exceeds its type", m.Name))
+	case m.BitWidth == 0 && m.Name != "":
+		panic(tok.Errorf("named bit-field '%s' has zero width", m.Name))
+	}
+}
+
 func (p *Parser) StructUnionDecl(structOrUnion string) *Type {
+	attr := &DeclAttr{}
+	p.Attributes(attr)
 	var tag *Token
 	if p.Current().Kind == TKIdentifier {
 		tag = p.Current()
 		p.Next()
+		p.Attributes(attr)
+	}
+
+	ty := TYStruct
+	if structOrUnion == "union" {
+		ty = TYUnion
 	}
 
 	if tag != nil && !p.Current().Equal(TKPunctuator, "{") {
+		// A bare "struct tag;" always declares the tag in the current scope,
+		// otherwise an unknown tag is implicitly declared as incomplete.
 		t := p.FindTags(tag.Val.(string))
+		if p.Current().Equal(TKPunctuator, ";") {
+			t = p.scopes[0].FindTag(tag.Val.(string))
+		}
 		if t == nil {
-			panic(tag.Errorf("unknown %s type", structOrUnion))
+			t = NewType(ty, nil, &StructVal{Name: tag, IsIncomplete: true})
+			p.PushTagScope(t)
 		}
 		return t
 	}
+	pack := p.packs[p.pos]
 	p.Consume(TKPunctuator, "{")
 
-	ty := TYStruct
-	if structOrUnion == "union" {
-		ty = TYUnion
+	// The tag is visible within its own members, so that they can point to
+	// the struct being defined. Defining a tag declared earlier in the same
+	// scope completes that type.
+	var t *Type
+	if tag != nil {
+		t = p.scopes[0].FindTag(tag.Val.(string))
+		if t != nil && !t.IsIncomplete() {
+			panic(tag.Errorf("redefinition of '%s %s'", structOrUnion, tag.Lexeme))
+		}
+	}
+	if t == nil {
+		t = NewType(ty, nil, &StructVal{Name: tag, IsIncomplete: true})
+		p.PushTagScope(t)
 	}
 
-	t := NewType(ty, nil, &StructVal{
-		Members: p.StructMembers(),
-		Name:    tag,
-	})
-	p.PushTagScope(t)
+	sv := t.Val.(*StructVal)
+	sv.Members = p.StructMembers(ty)
+	p.Attributes(attr)
+	sv.IsIncomplete = false
+	sv.Pack = pack
+	if attr.Packed {
+		sv.Pack = 1
+	}
+	sv.Align = attr.Align
+	*t = *NewType(ty, nil, sv)
 	return t
 }
 
 func (p *Parser) Postfix() *Node {
-	n := p.Primary()
+	return p.PostfixOps(p.Primary())
+}
 
+// PostfixOps parses the postfix operators applied to n.
+func (p *Parser) PostfixOps(n *Node) *Node {
 	for {
+		if p.Current().Equal(TKPunctuator, "(") {
+			tok := p.Current()
+			p.Next()
+			n = p.FuncCall(n, tok)
+			continue
+		}
+
 		if p.Current().Equal(TKPunctuator, "[") {
 			p.Next()
 			tok := p.Current()
@@ -631,11 +1341,12 @@ func (p *Parser) Postfix() *Node {
 			if n.Type.Kind != TYStruct && n.Type.Kind != TYUnion {
 				panic(p.Current().Errorf("not a struct or union"))
 			}
+			if n.Type.IsIncomplete() {
+				panic(p.Current().Errorf("member access into incomplete type"))
+			}
 
-			for _, m := range n.Type.Val.(*StructVal).Members {
-				if m.Name == p.Current().Lexeme {
-					return NewNode(NKMember, &MemberAccess{Struct: n, Member: m}, p.Current())
-				}
+			if m := n.Type.FindMember(p.Current().Lexeme); m != nil {
+				return NewNode(NKMember, &MemberAccess{Struct: n, Member: m}, p.Current())
 			}
 			panic(p.Current().Errorf("no such member"))
 		}
@@ -660,7 +1371,7 @@ func (p *Parser) Postfix() *Node {
 	}
 }
 
-func (p *Parser) FuncCall(tok *Token) *Node {
+func (p *Parser) FuncArgs() []*Node {
 	args := make([]*Node, 0)
 	first := true
 	for !p.Current().Equal(TKPunctuator, ")") {
@@ -671,10 +1382,188 @@ func (p *Parser) FuncCall(tok *Token) *Node {
 		args = append(args, p.Assign())
 	}
 	p.Next()
-	return NewNode(NKFuncCall, &FuncCall{
-		Name: tok.Val.(string),
-		Args: args,
-	}, tok)
+	return args
+}
+
+// FuncCall parses the arguments of a call to fn. Calls to a function
+// designator are direct, anything else is called through a function pointer.
+func (p *Parser) FuncCall(fn *Node, tok *Token) *Node {
+	t := fn.Type
+	if t.Kind == TYPtr {
+		t = t.Base
+	}
+	if t.Kind != TYFunc {
+		panic(tok.Errorf("called object is not a function or function pointer"))
+	}
+
+	call := &FuncCall{FuncType: t, Args: p.FuncArgs()}
+	if fn.Kind == NKVariable && fn.Variable.Object.Kind == OKFunction {
+		call.Name = fn.Variable.Object.Name
+	} else {
+		call.Ptr = fn
+	}
+
+	p.CallArgs(call, tok)
+	if t.Base.IsStructUnion() {
+		call.RetBuf = p.AddHiddenLocal(t.Base)
+	}
+	for i, arg := range call.Args {
+		call.Args[i] = p.StructArg(arg)
+	}
+
+	if f := t.Val.(*FuncVal); f.IsVariadic {
+		call.VarArgs = call.Args[len(f.Params):]
+		call.Args = call.Args[:len(f.Params)]
+		call.VarArea = p.AddHiddenLocal(NewType(TYArray, LongType, len(call.VarArgs)))
+	}
+
+	return NewNode(NKFuncCall, call, tok)
+}
+
+// CallArgs checks the arguments of a call against the prototype of the
+// callee, and converts them to the types of the parameters. Arguments without
+// a parameter, as for the "..." of a variadic function, are promoted to int
+// if they are smaller.
+func (p *Parser) CallArgs(call *FuncCall, tok *Token) {
+	name := "function"
+	if call.Name != "" {
+		name = fmt.Sprintf("function '%s'", call.Name)
+	}
+
+	f := call.FuncType.Val.(*FuncVal)
+	if !f.NoPrototype {
+		if len(call.Args) < len(f.Params) {
+			panic(tok.Errorf("too few arguments to %s", name))
+		}
+		if len(call.Args) > len(f.Params) && !f.IsVariadic {
+			panic(tok.Errorf("too many arguments to %s", name))
+		}
+	}
+
+	for i, arg := range call.Args {
+		if f.NoPrototype || i >= len(f.Params) {
+			call.Args[i] = promote(arg)
+			continue
+		}
+
+		param := f.Params[i]
+		switch {
+		case param.IsStructUnion() && !arg.Type.IsCompatible(param),
+			!param.IsStructUnion() && arg.Type.IsStructUnion():
+			panic(arg.Tok.Errorf("incompatible type for argument %d of %s", i+1, name))
+		case param.Kind == TYPtr && arg.Type.IsInteger() && !(arg.Kind == NKNum && arg.Num.Val == 0):
+			panic(arg.Tok.Errorf("passing argument %d of %s makes pointer from integer without a cast", i+1, name))
+		case param.IsInteger() && param.Kind != TYBool && arg.Type.Base != nil:
+			panic(arg.Tok.Errorf("passing argument %d of %s makes integer from pointer without a cast", i+1, name))
+		}
+		if !param.IsStructUnion() && arg.Type.Kind != param.Kind {
+			call.Args[i] = NewCast(arg, param)
+		}
+	}
+}
+
+// promote applies the integer promotions to arguments without a parameter
+// type.
+func promote(arg *Node) *Node {
+	switch arg.Type.Kind {
+	case TYChar, TYShort, TYBool:
+		return NewCast(arg, IntType)
+	}
+	return arg
+}
+
+// ResolveImplicitCalls checks calls to functions which were not declared
+// before being called against their later declaration. Functions which are
+// never declared are declared as returning int and taking the arguments of
+// their first call, and these declarations are returned.
+func (p *Parser) ResolveImplicitCalls() []*Object {
+	var decls []*Object
+	for _, n := range p.implicits {
+		call := n.FuncCall
+		f := p.FindVariable(call.Name)
+		if f == nil {
+			f = implicitDecl(call)
+			p.AddFunction(f)
+			decls = append(decls, f)
+		}
+		f.Function.IsReferenced = true
+		if f.Kind != OKFunction || f.Type.Base.Kind != TYInt || f.Type.Val.(*FuncVal).IsVariadic {
+			panic(n.Tok.Errorf("conflicting types for '%s'", call.Name))
+		}
+		call.FuncType = f.Type
+		p.CallArgs(call, n.Tok)
+	}
+	return decls
+}
+
+// implicitDecl declares the function called by call without a declaration.
+func implicitDecl(call *FuncCall) *Object {
+	f := &Function{IsPrototype: true}
+	types := make([]*Type, 0, len(call.Args))
+	for i, arg := range call.Args {
+		t := arg.Type
+		if t.Kind == TYArray || t.Kind == TYVLA {
+			t = NewType(TYPtr, t.Base, nil)
+		}
+		if t.IsStructUnion() {
+			panic(arg.Tok.Errorf("passing a struct to implicitly declared function '%s' is not supported", call.Name))
+		}
+		types = append(types, t)
+		f.Params = append(f.Params, &Object{Name: fmt.Sprintf("p%d", i), Type: t})
+	}
+	return &Object{
+		Name:     call.Name,
+		Kind:     OKFunction,
+		Type:     NewType(TYFunc, IntType, &FuncVal{Params: types}),
+		Function: f,
+	}
+}
+
+// StructArg makes the caller's copy of a struct or union argument, the callee
+// receives the address of the copy.
+func (p *Parser) StructArg(arg *Node) *Node {
+	if !arg.Type.IsStructUnion() {
+		return arg
+	}
+	tmp := p.AddHiddenLocal(arg.Type)
+	return NewNode(NKAssign, &Binary{Lhs: NewNode(NKVariable, &Variable{Object: tmp}, arg.Tok), Rhs: arg}, arg.Tok)
+}
+
+// VaBuiltin parses the va_* macros of <stdarg.h>, which are builtin for the
+// same reason as va_list.
+func (p *Parser) VaBuiltin(tok *Token) *Node {
+	name := strings.TrimPrefix(tok.Lexeme, "__builtin_")
+	p.Consume(TKPunctuator, "(")
+	ap := p.Assign()
+	if ap.Type != VaListType {
+		panic(ap.Tok.Errorf("expected a va_list"))
+	}
+
+	var n *Node
+	switch name {
+	case "va_start":
+		if p.fn == nil || !p.fn.Type.Val.(*FuncVal).IsVariadic {
+			panic(tok.Errorf("va_start used in function with fixed arguments"))
+		}
+		p.Consume(TKPunctuator, ",")
+		p.Assign()
+		area := p.FindVariable(VaAreaName)
+		n = NewNode(NKAssign, &Binary{Lhs: ap, Rhs: NewNode(NKVariable, &Variable{Object: area}, tok)}, tok)
+	case "va_copy":
+		p.Consume(TKPunctuator, ",")
+		n = NewNode(NKAssign, &Binary{Lhs: ap, Rhs: p.Assign()}, tok)
+	case "va_arg":
+		p.Consume(TKPunctuator, ",")
+		n = NewNode(NKVaArg, &Unary{Expr: ap}, tok)
+		n.Type = p.TypeName()
+		if n.Type.Kind == TYArray || n.Type.Kind == TYFunc {
+			panic(tok.Errorf("va_arg of an array or function type is not supported"))
+		}
+	case "va_end":
+		n = NewNode(NKNum, &Number{Val: 0}, tok)
+	}
+	p.Consume(TKPunctuator, ")")
+	return n
 }
 
 func (p *Parser) Primary() *Node {
@@ -697,19 +1586,76 @@ func (p *Parser) Primary() *Node {
 	}
 
 	if tok.Equal(TKKeyword, "sizeof") {
-		n := p.Unary()
-		return NewNode(NKNum, &Number{Val: n.Type.Size}, tok)
+		t, n := p.SizeofOperand()
+		if t.IsIncomplete() {
+			panic(tok.Errorf("invalid application of 'sizeof' to an incomplete type"))
+		}
+		if t.Kind == TYVLA && n == nil {
+			return NewNode(NKMul, &Binary{
+				Lhs: t.Val.(*VLAVal).Len,
+				Rhs: NewNode(NKNum, &Number{Val: t.Base.Size}, tok),
+			}, tok)
+		}
+		if t.Kind == TYVLA {
+			return NewNode(NKVariable, &Variable{Object: t.Val.(*VLAVal).Size}, tok)
+		}
+		return NewNode(NKNum, &Number{Val: t.Size}, tok)
+	}
+
+	if tok.Equal(TKKeyword, "_Generic") {
+		return p.GenericSelection(tok)
+	}
+
+	if tok.Equal(TKKeyword, "_Alignof") || tok.Equal(TKKeyword, "__alignof__") {
+		t, n := p.SizeofOperand()
+		if t.IsIncomplete() {
+			panic(tok.Errorf("invalid application of '%s' to an incomplete type", tok.Lexeme))
+		}
+		if n != nil && n.Kind == NKVariable {
+			return NewNode(NKNum, &Number{Val: n.Variable.Object.Alignment()}, tok)
+		}
+		return NewNode(NKNum, &Number{Val: t.Align}, tok)
 	}
 
 	if tok.Kind == TKIdentifier {
-		if p.Current().Equal(TKPunctuator, "(") {
+		variable := p.FindVariable(tok.Val.(string))
+		if variable == nil && isVaBuiltin(tok.Lexeme) {
+			return p.VaBuiltin(tok)
+		}
+		if variable == nil && (tok.Lexeme == "__builtin_alloca" || tok.Lexeme == "alloca") {
+			p.Consume(TKPunctuator, "(")
+			size := p.Assign()
+			p.Consume(TKPunctuator, ")")
+			return NewNode(NKAlloca, &Unary{Expr: size}, tok)
+		}
+		if variable == nil && (tok.Lexeme == "true" || tok.Lexeme == "false") {
+			// The constants of <stdbool.h>, unless shadowed.
+			val := 0
+			if tok.Lexeme == "true" {
+				val = 1
+			}
+			return NewNode(NKNum, &Number{Val: val}, tok)
+		}
+		if variable == nil && p.Current().Equal(TKPunctuator, "(") {
+			// Implicitly declared function, which is checked once the
+			// whole file is parsed.
 			p.Next()
-			return p.FuncCall(tok)
+			call := &FuncCall{Name: tok.Val.(string), Args: p.FuncArgs()}
+			for i, arg := range call.Args {
+				call.Args[i] = promote(arg)
+			}
+			n := NewNode(NKFuncCall, call, tok)
+			if p.unevaluated == 0 {
+				p.implicits = append(p.implicits, n)
+			}
+			return n
 		}
-		variable := p.FindVariable(tok.Val.(string))
 		if variable == nil {
 			panic(tok.Errorf("undefined variable '%s'", tok.Val.(string)))
 		}
+		if variable.Kind == OKFunction && p.unevaluated == 0 {
+			variable.Function.IsReferenced = true
+		}
 		return NewNode(NKVariable, &Variable{Object: variable}, tok)
 	}
 
@@ -730,6 +1676,231 @@ func (p *Parser) Primary() *Node {
 	panic(tok.Errorf("expected an expression, got '%s' instead", tok.Lexeme))
 }
 
+// GenericSelection parses "_Generic(expr, type: expr, ..., default: expr)",
+// which is the expression associated with the type of the controlling
+// expression after it is converted to a value.
+func (p *Parser) GenericSelection(tok *Token) *Node {
+	p.Consume(TKPunctuator, "(")
+	ctrl := p.Assign().Type
+	switch ctrl.Kind {
+	case TYArray, TYVLA:
+		ctrl = NewType(TYPtr, ctrl.Base, nil)
+	case TYFunc:
+		ctrl = NewType(TYPtr, ctrl, nil)
+	}
+
+	var (
+		types    []*Type
+		selected *Node
+		def      *Node
+	)
+	for p.Current().Equal(TKPunctuator, ",") {
+		p.Next()
+		assocTok := p.Current()
+		if assocTok.Equal(TKKeyword, "default") {
+			p.Next()
+			p.Consume(TKPunctuator, ":")
+			if def != nil {
+				panic(assocTok.Errorf("duplicate 'default' association"))
+			}
+			def = p.Assign()
+			continue
+		}
+
+		t := p.TypeName()
+		if t.IsIncomplete() || t.Kind == TYVLA {
+			panic(assocTok.Errorf("association has incomplete or variably modified type"))
+		}
+		for _, prev := range types {
+			if prev.IsCompatible(t) {
+				panic(assocTok.Errorf("association type is compatible with a previous association"))
+			}
+		}
+		types = append(types, t)
+		p.Consume(TKPunctuator, ":")
+		n := p.Assign()
+		if t.IsCompatible(ctrl) {
+			selected = n
+		}
+	}
+	p.Consume(TKPunctuator, ")")
+
+	if selected == nil {
+		selected = def
+	}
+	if selected == nil {
+		panic(tok.Errorf("controlling expression type not compatible with any association"))
+	}
+	return selected
+}
+
+// SizeofOperand parses the operand of sizeof or _Alignof, which is either a
+// parenthesized type name or an expression. The expression is nil for a
+// type name. An expression is not evaluated, only its type is used.
+func (p *Parser) SizeofOperand() (*Type, *Node) {
+	if p.Current().Equal(TKPunctuator, "(") {
+		pos := p.pos
+		p.Next()
+		if p.IsTypeName() {
+			tok := p.Current()
+			t := p.TypeName()
+			p.Consume(TKPunctuator, ")")
+			if p.Current().Equal(TKPunctuator, "{") {
+				p.unevaluated++
+				n := p.PostfixOps(p.CompoundLiteral(t, tok))
+				p.unevaluated--
+				return n.Type, n
+			}
+			return t, nil
+		}
+		p.MoveTo(pos)
+	}
+	p.unevaluated++
+	n := p.Unary()
+	p.unevaluated--
+	return n.Type, n
+}
+
+// ConstExpr parses an expression that must be a compile-time constant.
+func (p *Parser) ConstExpr() int {
+	return eval(p.Assign())
+}
+
+// eval computes the value of a constant expression.
+func eval(n *Node) int {
+	return evalReloc(n, nil)
+}
+
+// tryEval computes the value of n, reporting false if it isn't a constant
+// expression.
+func tryEval(n *Node) (v int, ok bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			if _, isErr := r.(error); !isErr {
+				panic(r)
+			}
+			ok = false
+		}
+	}()
+	return eval(n), true
+}
+
+// evalReloc computes the value of a constant expression, which may also be
+// the address of a global or function plus a constant if label is not nil.
+// The object whose address is taken is stored in label.
+func evalReloc(n *Node, label **Object) int {
+	switch n.Kind {
+	case NKNum:
+		return n.Num.Val
+	case NKNeg:
+		return castValue(-eval(n.Unary.Expr), n.Type)
+	case NKCast:
+		v := evalReloc(n.Unary.Expr, label)
+		if label != nil && *label != nil {
+			return v
+		}
+		return castValue(v, n.Type)
+	case NKComma:
+		return evalReloc(n.Binary.Rhs, label)
+	case NKAddr:
+		return evalAddr(n.Unary.Expr, label)
+	case NKVariable, NKStringLiteral:
+		if n.Type.Kind == TYArray || n.Type.Kind == TYFunc {
+			return evalAddr(n, label)
+		}
+	case NKAdd, NKSub:
+		// Pointer arithmetic, the integer is already scaled.
+		if n.Type.Base != nil {
+			v := evalReloc(n.Binary.Lhs, label)
+			if n.Kind == NKSub {
+				return v - eval(n.Binary.Rhs)
+			}
+			return v + eval(n.Binary.Rhs)
+		}
+	}
+
+	switch n.Kind {
+	case NKAdd, NKSub, NKMul, NKDiv, NKEq, NKNe, NKLt, NKLe:
+		if !n.Binary.Lhs.Type.IsInteger() || !n.Binary.Rhs.Type.IsInteger() {
+			break
+		}
+		lhs, rhs := eval(n.Binary.Lhs), eval(n.Binary.Rhs)
+		switch n.Kind {
+		case NKAdd:
+			return castValue(lhs+rhs, n.Type)
+		case NKSub:
+			return castValue(lhs-rhs, n.Type)
+		case NKMul:
+			return castValue(lhs*rhs, n.Type)
+		case NKDiv:
+			if rhs == 0 {
+				panic(n.Tok.Errorf("division by zero in a constant expression"))
+			}
+			return castValue(lhs/rhs, n.Type)
+		case NKEq:
+			return boolValue(lhs == rhs)
+		case NKNe:
+			return boolValue(lhs != rhs)
+		case NKLt:
+			return boolValue(lhs < rhs)
+		case NKLe:
+			return boolValue(lhs <= rhs)
+		}
+	}
+	panic(n.Tok.Errorf("expected a constant expression"))
+}
+
+// evalAddr computes the address of an lvalue in a constant expression.
+func evalAddr(n *Node, label **Object) int {
+	switch n.Kind {
+	case NKVariable, NKStringLiteral:
+		if label == nil || n.Variable.Object.Kind == OKLocal {
+			break
+		}
+		*label = n.Variable.Object
+		return 0
+	case NKDeRef:
+		return evalReloc(n.Unary.Expr, label)
+	case NKMember:
+		if n.MemberAccess.Member.IsBitfield {
+			break
+		}
+		return evalAddr(n.MemberAccess.Struct, label) + n.MemberAccess.Member.Offset
+	}
+	panic(n.Tok.Errorf("expected a constant expression"))
+}
+
+// castValue converts a constant to an integer type.
+func castValue(v int, t *Type) int {
+	switch t.Kind {
+	case TYBool:
+		return boolValue(v != 0)
+	case TYChar:
+		return int(int8(v))
+	case TYShort:
+		return int(int16(v))
+	case TYLong:
+		return v
+	default:
+		return int(int32(v))
+	}
+}
+
+func boolValue(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func isVaBuiltin(name string) bool {
+	switch strings.TrimPrefix(name, "__builtin_") {
+	case "va_start", "va_arg", "va_end", "va_copy":
+		return true
+	}
+	return false
+}
+
 func alignTo(n int, align int) int {
 	return (n + align - 1) / align * align
 }
@@ -0,0 +1,92 @@
+package cc
+
+import (
+	"fmt"
+	"os"
+)
+
+// Pass is an optimization of the IR of the program. Level is the lowest
+// optimization level it runs at.
+type Pass struct {
+	Name  string
+	Level int
+	Run   func(objects []*Object, funcs []*IRFunc, opts *Options) []*IRFunc
+}
+
+// perFunc makes a pass of a function applied to each one.
+func perFunc(pass func(f *IRFunc)) func([]*Object, []*IRFunc, *Options) []*IRFunc {
+	return func(_ []*Object, funcs []*IRFunc, _ *Options) []*IRFunc {
+		for _, f := range funcs {
+			pass(f)
+		}
+		return funcs
+	}
+}
+
+// Passes is the pipeline, in order. A pass may run more than once, as
+// inlining gives constants and dead code to clean up.
+var Passes = []*Pass{
+	{Name: "promote", Level: 0, Run: perFunc(PromoteLocals)},
+	{Name: "constfold", Level: 1, Run: perFunc(FoldConstants)},
+	{Name: "dce", Level: 1, Run: perFunc(EliminateDeadCode)},
+	{Name: "inline", Level: 2, Run: InlineFunctions},
+	{Name: "constfold", Level: 2, Run: perFunc(FoldConstants)},
+	{Name: "dce", Level: 2, Run: perFunc(EliminateDeadCode)},
+}
+
+// RunPasses optimizes the IR of the program with the passes of the
+// optimization level, along with those enabled and without those disabled
+// by name, printing it after the passes listed in opts.PrintAfter.
+func RunPasses(objects []*Object, funcs []*IRFunc, opts *Options) ([]*IRFunc, error) {
+	known := make(map[string]bool)
+	for _, p := range Passes {
+		known[p.Name] = true
+	}
+	for _, names := range [][]string{opts.EnablePasses, opts.DisablePasses, opts.PrintAfter} {
+		for _, name := range names {
+			if !known[name] && name != "all" {
+				return nil, fmt.Errorf("unknown pass '%s'", name)
+			}
+		}
+	}
+	contains := func(names []string, name string) bool {
+		for _, n := range names {
+			if n == name || n == "all" {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, p := range Passes {
+		enabled := p.Level <= opts.OptLevel || contains(opts.EnablePasses, p.Name)
+		if !enabled || contains(opts.DisablePasses, p.Name) {
+			continue
+		}
+		funcs = p.Run(objects, funcs, opts)
+		if contains(opts.PrintAfter, p.Name) {
+			if err := printAfter(p.Name, objects, funcs, opts); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return funcs, nil
+}
+
+func printAfter(name string, objects []*Object, funcs []*IRFunc, opts *Options) error {
+	w := opts.PrintTo
+	if w == nil {
+		w = os.Stderr
+	}
+	if _, err := fmt.Fprintf(w, "; after %s\n", name); err != nil {
+		return err
+	}
+	if !opts.PrintWat {
+		return WriteIR(w, funcs)
+	}
+	m, err := NewCodegen(objects, funcs, opts).Gen()
+	if err != nil {
+		return err
+	}
+	return WriteWat(w, m)
+}
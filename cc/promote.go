@@ -0,0 +1,112 @@
+package cc
+
+// PromoteLocals moves the scalar locals whose address never escapes out of
+// the frame into registers, which become wasm locals. A local escapes unless
+// its address is only used to load or store the whole of it. The remaining
+// locals are laid out again, so the frame shrinks or disappears.
+func PromoteLocals(f *IRFunc) {
+	promoted := make(map[*Object]*IRReg)
+	frames := make(map[*IRReg]*Object)
+	for _, b := range f.Blocks {
+		for _, instr := range b.Instrs {
+			if instr.Op != IRFrame {
+				continue
+			}
+			o := instr.Object
+			frames[instr.Dst] = o
+			if _, ok := promoted[o]; !ok && !o.Type.IsAggregate() {
+				promoted[o] = nil
+			}
+		}
+	}
+
+	for _, b := range f.Blocks {
+		for _, instr := range b.Instrs {
+			for i, a := range instr.Args {
+				o, ok := frames[a]
+				if !ok {
+					continue
+				}
+				whole := instr.Op == IRMemFill || (instr.Op == IRLoad || instr.Op == IRStore) && instr.Size == o.Type.Size
+				if i > 0 || !whole {
+					delete(promoted, o)
+				}
+			}
+		}
+	}
+	if len(promoted) == 0 {
+		return
+	}
+
+	// A parameter keeps its value in the register it is passed in.
+	for i, param := range f.Object.Function.Params {
+		if _, ok := promoted[param]; ok {
+			promoted[param] = f.Params[i]
+		}
+	}
+	for _, o := range f.Frame {
+		if r, ok := promoted[o]; ok && r == nil {
+			promoted[o] = f.NewReg(o.Type.WasmType())
+		}
+	}
+
+	for _, b := range f.Blocks {
+		var instrs []*IRInstr
+		for _, instr := range b.Instrs {
+			var o *Object
+			if len(instr.Args) > 0 {
+				o = frames[instr.Args[0]]
+			}
+			r, ok := promoted[o]
+			switch {
+			case instr.Op == IRFrame && promoted[instr.Object] != nil:
+				continue
+			case !ok:
+			case instr.Op == IRMemFill:
+				instr = &IRInstr{Op: IRConst, Dst: r}
+			case instr.Op == IRLoad:
+				instr = &IRInstr{Op: IRCopy, Dst: instr.Dst, Args: []*IRReg{r}}
+			case instr.Op == IRStore && instr.Args[1] == r:
+				// The parameter stored to its own slot in the prologue.
+				continue
+			case instr.Op == IRStore:
+				instr = &IRInstr{Op: IRCopy, Dst: r, Args: []*IRReg{instr.Args[1]}}
+				instr, instrs = truncate(f, o.Type, instr, instrs)
+			}
+			instrs = append(instrs, instr)
+		}
+		b.Instrs = instrs
+	}
+
+	frame := f.Frame[:0]
+	for _, o := range f.Frame {
+		if promoted[o] == nil {
+			frame = append(frame, o)
+		}
+	}
+	f.Frame = frame
+	f.LayoutFrame()
+}
+
+// truncate makes the copy to a register holding a local of type t behave
+// like a store to memory, which keeps only the bytes of t: char and short
+// are sign-extended from their width, and _Bool keeps its low byte. The
+// instructions before it are appended to instrs.
+func truncate(f *IRFunc, t *Type, instr *IRInstr, instrs []*IRInstr) (*IRInstr, []*IRInstr) {
+	if t.Size >= wasmSize(t.WasmType()) {
+		return instr, instrs
+	}
+	dst := instr.Dst
+	instr.Dst = f.NewReg(dst.Type)
+	instrs = append(instrs, instr)
+	switch {
+	case t.Kind == TYBool:
+		mask := f.NewReg("i32")
+		instrs = append(instrs, &IRInstr{Op: IRConst, Dst: mask, Imm: 0xff})
+		return &IRInstr{Op: IRAnd, Dst: dst, Args: []*IRReg{instr.Dst, mask}}, instrs
+	case t.Size == 1:
+		return &IRInstr{Op: IRExtend8, Dst: dst, Args: []*IRReg{instr.Dst}}, instrs
+	default:
+		return &IRInstr{Op: IRExtend16, Dst: dst, Args: []*IRReg{instr.Dst}}, instrs
+	}
+}
@@ -2,7 +2,6 @@ package cc
 
 import (
 	"errors"
-	"fmt"
 	"strconv"
 	"strings"
 	"unicode"
@@ -49,6 +48,15 @@ func (s *Scanner) Scan() ([]*Token, error) {
 			continue
 		}
 
+		if s.code[0] == '#' {
+			tok, err := s.readPragma()
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, tok)
+			continue
+		}
+
 		if p, pl := readPunctuator(s.code); pl > 0 {
 			tokens = append(tokens, NewToken(TKPunctuator, p, s.pos, nil, s.source))
 			s.skip(pl)
@@ -84,16 +92,16 @@ func (s *Scanner) Scan() ([]*Token, error) {
 
 		if s.code[0] == '"' {
 			var (
-				r   []rune
+				bs  []byte
 				l   int
 				err error
 			)
-			if r, l, err = readStringLiteral(s.code); err != nil {
+			if bs, l, err = readStringLiteral(s.code); err != nil {
 				return nil, err
 			}
 			tokens = append(tokens, NewToken(TKString, string(s.code[:l]), s.pos, &String{
-				Type: NewType(TYArray, CharType, len(r)+1),
-				Val:  []byte(string(r)),
+				Type: NewType(TYArray, CharType, len(bs)+1),
+				Val:  bs,
 			}, s.source))
 			s.skip(l)
 			continue
@@ -106,6 +114,31 @@ func (s *Scanner) Scan() ([]*Token, error) {
 	return tokens, nil
 }
 
+// readPragma reads a "#pragma" line into a single token whose value is the
+// tokens following "pragma", the parser interprets the ones it knows.
+// Other preprocessing directives are not supported.
+func (s *Scanner) readPragma() (*Token, error) {
+	l := 0
+	for l < len(s.code) && s.code[l] != '\n' {
+		l += 1
+	}
+
+	pos := s.pos
+	pos.Col += 1
+	sub := &Scanner{source: s.source, code: s.code[1:l], pos: pos}
+	args, err := sub.Scan()
+	if err != nil {
+		return nil, err
+	}
+	if !args[0].Equal(TKIdentifier, "pragma") {
+		return nil, NewToken(TKUnknown, "#", s.pos, nil, s.source).Errorf("preprocessing directives are not supported")
+	}
+
+	tok := NewToken(TKPragma, string(s.code[:l]), s.pos, args[1:], s.source)
+	s.skip(l)
+	return tok, nil
+}
+
 func (s *Scanner) skip(n int) {
 	if n == 1 && s.code[0] == '\n' {
 		s.pos.Col = 0
@@ -126,6 +159,10 @@ func parseInt(s []rune) (num int, l int, err error) {
 }
 
 func readPunctuator(s []rune) (string, int) {
+	if len(s) >= 3 && string(s[:3]) == "..." {
+		return "...", 3
+	}
+
 	if len(s) >= 2 {
 		p := string(s[:2])
 		switch p {
@@ -134,7 +171,7 @@ func readPunctuator(s []rune) (string, int) {
 		}
 	}
 
-	if strings.ContainsRune("+-*/(){}<>[],;=&.", s[0]) {
+	if strings.ContainsRune("+-*/(){}<>[],;=&.:", s[0]) {
 		return string(s[0]), 1
 	}
 
@@ -179,21 +216,19 @@ func isHex(r rune) bool {
 
 }
 
-func readEscapedChar(s []rune) (rs []rune, l int, err error) {
+func readEscapedChar(s []rune) (c byte, l int, err error) {
 	switch s[l] {
 	case '0', '1', '2', '3', '4', '5', '6', '7':
-		c := s[l] - '0'
+		c = byte(s[l] - '0')
 		l += 1
 		if l < len(s) && '0' <= s[l] && s[l] <= '7' {
-			c = (c << 3) + s[l] - '0'
+			c = (c << 3) + byte(s[l]-'0')
 			l += 1
 			if l < len(s) && '0' <= s[l] && s[l] <= '7' {
-				c = (c << 3) + s[l] - '0'
+				c = (c << 3) + byte(s[l]-'0')
 				l += 1
 			}
 		}
-
-		rs = []rune(fmt.Sprintf("\\%02x", c))
 		return
 	case 'x':
 		l += 1
@@ -202,49 +237,35 @@ func readEscapedChar(s []rune) (rs []rune, l int, err error) {
 			return
 		}
 
-		var c rune
 		for ; isHex(s[l]); l++ {
-			c = (c << 4) + hexToInt(s[l])
+			c = (c << 4) + byte(hexToInt(s[l]))
 		}
-		rs = []rune(fmt.Sprintf("\\%02x", c))
 		return
 	case 'a':
-		rs = []rune("\\07")
-		l = l + 1
-		return
+		return 7, l + 1, nil
 	case 'b':
-		rs = []rune("\\08")
-		l = l + 1
-		return
+		return 8, l + 1, nil
+	case 't':
+		return '\t', l + 1, nil
+	case 'n':
+		return '\n', l + 1, nil
+	case 'v':
+		return 11, l + 1, nil
 	case 'f':
-		rs = []rune("\\0c")
-		l = l + 1
-		return
+		return 12, l + 1, nil
 	case 'r':
-		rs = []rune("\\0d")
-		l = l + 1
-		return
-	case 'v':
-		rs = []rune("\\0b")
-		l = l + 1
-		return
+		return 13, l + 1, nil
 	case 'e':
-		rs = []rune("\\1b")
-		l = l + 1
-		return
-	case 't', 'n':
-		rs = []rune{'\\', s[l]}
-		l = l + 1
-		return
+		return 27, l + 1, nil
 	default:
 		// TODO: warning: invalid escape sequence
-		rs = []rune{s[l]}
-		l = l + 1
-		return
+		return byte(s[l]), l + 1, nil
 	}
 }
 
-func readStringLiteral(s []rune) (rs []rune, l int, err error) {
+// readStringLiteral returns the bytes of a string literal, without the
+// terminating null byte, and the length of its source.
+func readStringLiteral(s []rune) (bs []byte, l int, err error) {
 	l = 1
 	for l < len(s) && s[l] != '"' {
 		if s[l] == '\n' || s[l] == '\000' {
@@ -257,19 +278,19 @@ func readStringLiteral(s []rune) (rs []rune, l int, err error) {
 				return
 			}
 			var (
-				c  []rune
+				c  byte
 				ll int
 			)
 			c, ll, err = readEscapedChar(s[l+1:])
 			if err != nil {
 				return
 			}
-			rs = append(rs, c...)
+			bs = append(bs, c)
 			l += ll + 1
 			continue
 		}
 
-		rs = append(rs, s[l])
+		bs = append(bs, string(s[l])...)
 		l += 1
 	}
 
@@ -284,7 +305,9 @@ func readStringLiteral(s []rune) (rs []rune, l int, err error) {
 
 func isKeyword(n string) bool {
 	switch n {
-	case "return", "if", "else", "for", "while", "long", "int", "short", "char", "sizeof", "struct", "union":
+	case "return", "if", "else", "for", "while", "long", "int", "short", "char", "sizeof", "struct", "union",
+		"_Bool", "_Alignof", "_Alignas", "__alignof__", "__attribute__",
+		"static", "_Static_assert", "_Generic", "default":
 		return true
 	}
 	return false
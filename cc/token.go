@@ -14,6 +14,7 @@ const (
 	TKKeyword
 	TKString
 	TKNumber
+	TKPragma
 	TKEof
 	TKUnknown
 )
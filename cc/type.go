@@ -12,16 +12,54 @@ const (
 	TYInt
 	TYShort
 	TYChar
+	TYBool
 	TYFunc
 	TYArray
 	TYStruct
 	TYUnion
+	TYVLA
 	TYUnknown
 )
 
 type StructVal struct {
 	Members []*StructMember
 	Name    *Token
+
+	// An incomplete struct or union has been declared but not yet defined,
+	// it can only be used through pointers.
+	IsIncomplete bool
+
+	// Pack is the maximum natural alignment of members, set by the packed
+	// attribute or "#pragma pack". Align is the minimum alignment of the
+	// whole type set by the aligned attribute. Zero means no limit.
+	Pack  int
+	Align int
+}
+
+// MemberAlign returns the alignment of m in a struct or union with sv.
+func (sv *StructVal) MemberAlign(m *StructMember) int {
+	align := m.Type.Align
+	if sv.Pack > 0 && align > sv.Pack {
+		align = sv.Pack
+	}
+	if m.Align > align {
+		align = m.Align
+	}
+	return align
+}
+
+type VLAVal struct {
+	Len  *Node   // number of elements, evaluated when the array is declared
+	Size *Object // hidden local holding the size in bytes
+}
+
+type FuncVal struct {
+	Params     []*Type
+	IsVariadic bool
+
+	// A function declared with empty parentheses has no prototype, the
+	// number and types of its parameters are unknown to callers.
+	NoPrototype bool
 }
 
 type Type struct {
@@ -33,7 +71,7 @@ type Type struct {
 }
 
 func (t *Type) IsInteger() bool {
-	return t.Kind == TYLong || t.Kind == TYInt || t.Kind == TYShort || t.Kind == TYChar
+	return t.Kind == TYLong || t.Kind == TYInt || t.Kind == TYShort || t.Kind == TYChar || t.Kind == TYBool
 }
 
 func (t *Type) WasmType() string {
@@ -42,8 +80,6 @@ func (t *Type) WasmType() string {
 		return "i64"
 	case TYInt, TYPtr:
 		return "i32"
-	case TYArray:
-		return t.Base.WasmType()
 	default:
 		return "i32"
 	}
@@ -53,11 +89,125 @@ func (t *Type) WasmLoad() string {
 	switch t.Kind {
 	case TYChar:
 		return "load8_s"
+	case TYBool:
+		return "load8_u"
+	case TYShort:
+		return "load16_s"
 	default:
 		return "load"
 	}
 }
 
+func (t *Type) WasmStore() string {
+	switch t.Kind {
+	case TYChar, TYBool:
+		return "store8"
+	case TYShort:
+		return "store16"
+	default:
+		return "store"
+	}
+}
+
+func (t *Type) IsStructUnion() bool {
+	return t.Kind == TYStruct || t.Kind == TYUnion
+}
+
+func (t *Type) IsIncomplete() bool {
+	return (t.IsStructUnion() && t.Val.(*StructVal).IsIncomplete) || t.IsUnsizedArray()
+}
+
+// IsUnsizedArray reports whether the type is an array of unknown length,
+// such as a flexible array member "int data[];".
+func (t *Type) IsUnsizedArray() bool {
+	return t.Kind == TYArray && t.Val.(int) < 0
+}
+
+// IsAggregate reports whether values of the type are represented by their
+// address rather than being loaded onto the wasm stack.
+func (t *Type) IsAggregate() bool {
+	return t.Kind == TYArray || t.Kind == TYStruct || t.Kind == TYUnion || t.Kind == TYFunc || t.Kind == TYVLA
+}
+
+// FindMember looks up a member of a struct or union by name, including the
+// members of anonymous structs and unions which are returned with their
+// offset in t.
+func (t *Type) FindMember(name string) *StructMember {
+	for _, m := range t.Val.(*StructVal).Members {
+		if m.Name == name {
+			return m
+		}
+		if m.Name != "" || m.IsBitfield || !m.Type.IsStructUnion() {
+			continue
+		}
+		if inner := m.Type.FindMember(name); inner != nil {
+			flat := *inner
+			flat.Offset += m.Offset
+			return &flat
+		}
+	}
+	return nil
+}
+
+// MemberNames returns the names of the members of a struct or union,
+// including the members of anonymous structs and unions.
+func (t *Type) MemberNames() []string {
+	var names []string
+	for _, m := range t.Val.(*StructVal).Members {
+		switch {
+		case m.Name != "":
+			names = append(names, m.Name)
+		case !m.IsBitfield && m.Type.IsStructUnion():
+			names = append(names, m.Type.MemberNames()...)
+		}
+	}
+	return names
+}
+
+// IsCompatible reports whether two types are compatible, i.e. whether they
+// could be declarations of the same object.
+func (t *Type) IsCompatible(other *Type) bool {
+	if t == other {
+		return true
+	}
+
+	switch {
+	case t.Kind == TYVLA && (other.Kind == TYVLA || other.Kind == TYArray),
+		t.Kind == TYArray && other.Kind == TYVLA:
+		return t.Base.IsCompatible(other.Base)
+	case t.Kind != other.Kind:
+		return false
+	}
+
+	switch t.Kind {
+	case TYPtr:
+		return t.Base.IsCompatible(other.Base)
+	case TYArray:
+		if !t.IsUnsizedArray() && !other.IsUnsizedArray() && t.Val.(int) != other.Val.(int) {
+			return false
+		}
+		return t.Base.IsCompatible(other.Base)
+	case TYFunc:
+		f1, f2 := t.Val.(*FuncVal), other.Val.(*FuncVal)
+		if f1.NoPrototype || f2.NoPrototype {
+			return t.Base.IsCompatible(other.Base)
+		}
+		if !t.Base.IsCompatible(other.Base) || f1.IsVariadic != f2.IsVariadic || len(f1.Params) != len(f2.Params) {
+			return false
+		}
+		for i := range f1.Params {
+			if !f1.Params[i].IsCompatible(f2.Params[i]) {
+				return false
+			}
+		}
+		return true
+	case TYStruct, TYUnion:
+		// Every struct or union definition is a distinct type.
+		return t.Val == other.Val
+	}
+	return true
+}
+
 // Resize recalculates size and align recursively
 func (t *Type) Resize() {
 	if t.Base != nil {
@@ -74,40 +224,94 @@ var (
 	ShortType = NewType(TYShort, nil, nil)
 	IntType   = NewType(TYInt, nil, nil)
 	CharType  = NewType(TYChar, nil, nil)
+	BoolType  = NewType(TYBool, nil, nil)
+
+	// A va_list points into the area the caller spilled variadic arguments
+	// to, with each argument occupying an 8-byte slot.
+	VaListType = NewType(TYPtr, CharType, nil)
 )
 
+// VaAreaName is the hidden last parameter of a variadic function.
+const VaAreaName = "__va_area__"
+
+// RetBufName is the hidden first parameter of a function returning a struct
+// or union, pointing to where the caller wants the result.
+const RetBufName = "__ret_buf__"
+
+// VaSlotSize is the size of each variadic argument in the va area.
+const VaSlotSize = 8
+
 func NewType(k TypeKind, base *Type, val interface{}) *Type {
 	size, align := 1, 1
 	switch k {
-	case TYChar:
+	case TYChar, TYBool:
 		size, align = 1, 1
 	case TYShort:
 		size, align = 2, 2
 	case TYInt, TYPtr:
 		size, align = 4, 4
+	case TYVLA:
+		// A variable length array object holds the address of its elements,
+		// which are allocated on the stack at runtime.
+		size, align = 4, 4
 	case TYLong:
 		size, align = 8, 8
 	case TYArray:
 		size = base.Size * val.(int)
 		align = base.Align
+		if val.(int) < 0 {
+			size = 0
+		}
 	case TYStruct:
-		offset := 0
-		for _, m := range val.(*StructVal).Members {
-			offset = alignTo(offset, m.Type.Align)
-			m.Offset = offset
-			offset += m.Type.Size
-			align = int(math.Max(float64(align), float64(m.Type.Align)))
+		if val.(*StructVal).IsIncomplete {
+			size = -1
+			break
+		}
+
+		// Members are laid out in bits so that bit-fields can share the
+		// storage unit of their type as long as they don't straddle it.
+		sv := val.(*StructVal)
+		bits := 0
+		for _, m := range sv.Members {
+			unit := m.Type.Size * 8
+			switch {
+			case m.IsBitfield && m.BitWidth == 0:
+				bits = alignTo(bits, unit)
+			case m.IsBitfield:
+				if bits/unit != (bits+m.BitWidth-1)/unit {
+					bits = alignTo(bits, unit)
+				}
+				m.Offset = bits / unit * m.Type.Size
+				m.BitOffset = bits % unit
+				bits += m.BitWidth
+			default:
+				bits = alignTo(bits, sv.MemberAlign(m)*8)
+				m.Offset = bits / 8
+				bits += m.Type.Size * 8
+			}
+
+			// Unnamed bit-fields don't affect the alignment of the struct.
+			if !m.IsBitfield || m.Name != "" {
+				align = int(math.Max(float64(align), float64(sv.MemberAlign(m))))
+			}
 		}
-		size = alignTo(offset, align)
+		align = int(math.Max(float64(align), float64(sv.Align)))
+		size = alignTo(alignTo(bits, 8)/8, align)
 	case TYUnion:
-		for _, m := range val.(*StructVal).Members {
+		if val.(*StructVal).IsIncomplete {
+			size = -1
+			break
+		}
+		sv := val.(*StructVal)
+		for _, m := range sv.Members {
 			if m.Type.Size > size {
 				size = m.Type.Size
 			}
-			if m.Type.Align > align {
-				align = m.Type.Align
+			if sv.MemberAlign(m) > align && (!m.IsBitfield || m.Name != "") {
+				align = sv.MemberAlign(m)
 			}
 		}
+		align = int(math.Max(float64(align), float64(sv.Align)))
 		size = alignTo(size, align)
 	}
 	return &Type{
@@ -0,0 +1,118 @@
+package cc
+
+import "strings"
+
+// WasmModule is an in-memory WebAssembly module. Codegen builds it, and
+// WriteWat and WriteWasm serialize it. Functions, globals, locals and labels
+// are referred to by name, without the "$" of the text format.
+type WasmModule struct {
+	Types   []*WasmSig // types of call_indirect, referred to by index
+	Imports []*WasmImport
+	Funcs   []*WasmFunc
+	Table   []string // functions in the table, from slot 1 on
+	Memory  *WasmMemory
+	Globals []*WasmGlobal
+	Data    []*WasmData
+}
+
+// WasmSig is the type of a function.
+type WasmSig struct {
+	Params  []string
+	Results []string
+}
+
+// String returns the signature in the text format.
+func (s *WasmSig) String() string {
+	var sb strings.Builder
+	sb.WriteString("(func")
+	for _, p := range s.Params {
+		sb.WriteString(" (param " + p + ")")
+	}
+	for _, r := range s.Results {
+		sb.WriteString(" (result " + r + ")")
+	}
+	sb.WriteString(")")
+	return sb.String()
+}
+
+// WasmImport is a function imported from the host.
+type WasmImport struct {
+	Module string
+	Name   string
+	Func   string // name of the function within the module
+	Sig    *WasmSig
+}
+
+type WasmFunc struct {
+	Name    string
+	Export  string // empty unless exported
+	Params  []*WasmLocal
+	Results []string
+	Locals  []*WasmLocal
+	Body    []*Instr
+}
+
+type WasmLocal struct {
+	Name string
+	Type string
+}
+
+type WasmMemory struct {
+	Pages  int
+	Export string
+}
+
+type WasmGlobal struct {
+	Name    string // may be empty for exported constants
+	Export  string
+	Type    string
+	Mutable bool
+	Init    int64
+}
+
+// WasmData is an active data segment.
+type WasmData struct {
+	Offset int
+	Bytes  []byte
+}
+
+// Instr is a single instruction. Which immediate is used depends on Op:
+// Name is the label, local, global or function referred to, and Val is the
+// value of a constant, the offset of a load or store, or the type index of
+// call_indirect.
+type Instr struct {
+	Op   string
+	Name string
+	Val  int64
+}
+
+// FuncIndex returns the index of the named function, imports first.
+func (m *WasmModule) FuncIndex(name string) (int, bool) {
+	for i, imp := range m.Imports {
+		if imp.Func == name {
+			return i, true
+		}
+	}
+	for i, f := range m.Funcs {
+		if f.Name == name {
+			return len(m.Imports) + i, true
+		}
+	}
+	return 0, false
+}
+
+// GlobalIndex returns the index of the named global.
+func (m *WasmModule) GlobalIndex(name string) (int, bool) {
+	for i, g := range m.Globals {
+		if g.Name == name {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// isMemoryOp reports whether the instruction is a load or a store, whose
+// immediate is an offset.
+func isMemoryOp(op string) bool {
+	return strings.Contains(op, ".load") || strings.Contains(op, ".store")
+}
@@ -0,0 +1,131 @@
+package cc
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// WriteWat writes the module in the WebAssembly text format.
+func WriteWat(w io.Writer, m *WasmModule) error {
+	p := &watPrinter{}
+	p.Module(m)
+	_, err := io.WriteString(w, p.sb.String())
+	return err
+}
+
+type watPrinter struct {
+	sb     strings.Builder
+	indent int
+}
+
+func (p *watPrinter) Printf(format string, a ...interface{}) {
+	p.sb.WriteString(strings.Repeat("  ", p.indent))
+	_, _ = fmt.Fprintf(&p.sb, format, a...)
+}
+
+func (p *watPrinter) Module(m *WasmModule) {
+	p.Printf("(module\n")
+	p.indent++
+	for _, imp := range m.Imports {
+		p.Printf("(import \"%s\" \"%s\" (func $%s%s))\n", watString([]byte(imp.Module)), watString([]byte(imp.Name)), imp.Func, sigFields(imp.Sig))
+	}
+	for i, sig := range m.Types {
+		p.Printf("(type $T%d %s)\n", i, sig)
+	}
+	for _, d := range m.Data {
+		p.Printf("(data (i32.const %d) \"%s\")\n", d.Offset, watString(d.Bytes))
+	}
+	for _, f := range m.Funcs {
+		p.Func(f)
+	}
+
+	p.Printf("(table $table %d funcref)\n", len(m.Table)+1)
+	if len(m.Table) > 0 {
+		p.Printf("(elem (i32.const 1) $%s)\n", strings.Join(m.Table, " $"))
+	}
+	if m.Memory != nil {
+		p.Printf("(memory $memory%s %d)\n", exportField(m.Memory.Export), m.Memory.Pages)
+	}
+	for _, g := range m.Globals {
+		name := ""
+		if g.Name != "" {
+			name = " $" + g.Name
+		}
+		t := g.Type
+		if g.Mutable {
+			t = "(mut " + t + ")"
+		}
+		p.Printf("(global%s%s %s (%s.const %d))\n", name, exportField(g.Export), t, g.Type, g.Init)
+	}
+	p.indent--
+	p.Printf(")\n")
+}
+
+func (p *watPrinter) Func(f *WasmFunc) {
+	header := fmt.Sprintf("(func $%s%s", f.Name, exportField(f.Export))
+	for _, param := range f.Params {
+		header += fmt.Sprintf(" (param $%s %s)", param.Name, param.Type)
+	}
+	for _, r := range f.Results {
+		header += fmt.Sprintf(" (result %s)", r)
+	}
+	p.Printf("%s\n", header)
+	p.indent++
+	for _, l := range f.Locals {
+		p.Printf("(local $%s %s)\n", l.Name, l.Type)
+	}
+	for _, instr := range f.Body {
+		if instr.Op == "end" || instr.Op == "else" {
+			p.indent--
+		}
+		p.Printf("%s\n", instr)
+		if instr.Op == "block" || instr.Op == "loop" || instr.Op == "if" || instr.Op == "else" {
+			p.indent++
+		}
+	}
+	p.indent--
+	p.Printf(")\n")
+}
+
+// String returns the instruction in the text format.
+func (i *Instr) String() string {
+	switch {
+	case i.Op == "i32.const" || i.Op == "i64.const":
+		return fmt.Sprintf("%s %d", i.Op, i.Val)
+	case i.Op == "call_indirect":
+		return fmt.Sprintf("%s (type $T%d)", i.Op, i.Val)
+	case isMemoryOp(i.Op) && i.Val != 0:
+		return fmt.Sprintf("%s offset=%d", i.Op, i.Val)
+	case i.Name != "":
+		return fmt.Sprintf("%s $%s", i.Op, i.Name)
+	}
+	return i.Op
+}
+
+func exportField(name string) string {
+	if name == "" {
+		return ""
+	}
+	return fmt.Sprintf(" (export \"%s\")", watString([]byte(name)))
+}
+
+// sigFields returns the params and results of a signature as fields of a
+// function.
+func sigFields(sig *WasmSig) string {
+	s := sig.String()
+	return strings.TrimSuffix(strings.TrimPrefix(s, "(func"), ")")
+}
+
+// watString escapes bytes for a WAT string literal.
+func watString(bs []byte) string {
+	var sb strings.Builder
+	for _, b := range bs {
+		if b >= 0x20 && b < 0x7f && b != '"' && b != '\\' {
+			sb.WriteByte(b)
+			continue
+		}
+		_, _ = fmt.Fprintf(&sb, "\\%02x", b)
+	}
+	return sb.String()
+}
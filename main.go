@@ -5,12 +5,47 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"strings"
 )
 
 func main() {
+	// Options come first, followed by a file name or "-c code".
+	opts := &cc.Options{}
+	args := os.Args[1:]
+	for len(args) > 0 && (strings.HasPrefix(args[0], "--") || strings.HasPrefix(args[0], "-O")) && args[0] != "--code" {
+		switch {
+		case args[0] == "-O0", args[0] == "-O1", args[0] == "-O2":
+			opts.OptLevel, opts.OptSize = int(args[0][2]-'0'), false
+		case args[0] == "-Os":
+			opts.OptLevel, opts.OptSize = 2, true
+		case args[0] == "--emit=wat":
+			opts.Format = cc.FormatWat
+		case args[0] == "--emit=wasm":
+			opts.Format = cc.FormatWasm
+		case args[0] == "--emit=ir":
+			opts.Format = cc.FormatIR
+		case args[0] == "--export-all":
+			opts.ExportAll = true
+		case strings.HasPrefix(args[0], "--export="):
+			opts.Exports = append(opts.Exports, strings.Split(strings.TrimPrefix(args[0], "--export="), ",")...)
+		case strings.HasPrefix(args[0], "--enable-pass="):
+			opts.EnablePasses = append(opts.EnablePasses, strings.Split(strings.TrimPrefix(args[0], "--enable-pass="), ",")...)
+		case strings.HasPrefix(args[0], "--disable-pass="):
+			opts.DisablePasses = append(opts.DisablePasses, strings.Split(strings.TrimPrefix(args[0], "--disable-pass="), ",")...)
+		case strings.HasPrefix(args[0], "--print-after="):
+			opts.PrintAfter = append(opts.PrintAfter, strings.Split(strings.TrimPrefix(args[0], "--print-after="), ",")...)
+		case args[0] == "--print-wat":
+			opts.PrintWat = true
+		default:
+			_, _ = fmt.Fprintf(os.Stderr, "%s: unknown option '%s'\n", os.Args[0], args[0])
+			return
+		}
+		args = args[1:]
+	}
+
 	var content string
-	if len(os.Args) == 2 {
-		fileName := os.Args[1]
+	if len(args) == 1 {
+		fileName := args[0]
 		f, err := os.Open(fileName)
 		if err != nil {
 			_, _ = fmt.Fprintln(os.Stderr, err)
@@ -23,14 +58,14 @@ func main() {
 			return
 		}
 		content = string(contentBytes)
-	} else if len(os.Args) == 3 && (os.Args[1] == "-c" || os.Args[1] == "--code") {
-		content = os.Args[2]
+	} else if len(args) == 2 && (args[0] == "-c" || args[0] == "--code") {
+		content = args[1]
 	} else {
 		_, _ = fmt.Fprintf(os.Stderr, "%s: invalid number of arguments\n", os.Args[0])
 		return
 	}
 
-	err := cc.Compile(os.Stdout, []rune(content))
+	err := cc.CompileWithOptions(os.Stdout, []rune(content), opts)
 	if err != nil {
 		_, _ = fmt.Fprintln(os.Stderr, err)
 	}
@@ -1,6 +1,7 @@
 package tests
 
 import (
+	"bytes"
 	"cc/cc"
 	"fmt"
 	"github.com/bytecodealliance/wasmtime-go"
@@ -14,29 +15,77 @@ type Assert struct {
 }
 
 func (a Assert) Eval(expected interface{}, s string) {
+	a.EvalWithHost(expected, s, nil)
+}
+
+// EvalWithHost is like Eval, but the module may import the host functions in
+// funcs, which are keyed by "module.name". The program is compiled to text,
+// which is assembled by wasmtime, and directly to a binary module at each
+// optimization level, and all must give the expected result.
+func (a Assert) EvalWithHost(expected interface{}, s string, funcs map[string]interface{}) {
 	sb := new(strings.Builder)
 	err := cc.Compile(sb, []rune(s))
 	if err != nil {
 		a.t.Errorf("Compile failed, error:\n%s\ncode: %s", err.Error(), s)
+		return
 	}
-
 	wasm, err := wasmtime.Wat2Wasm(sb.String())
 	if err != nil {
 		a.t.Errorf("Wat2Wasm failed, error:\n%s\ncode: %s", err.Error(), s)
+		return
 	}
+	a.run(expected, s, wasm, funcs)
+
+	for _, opts := range []*cc.Options{{Format: cc.FormatWasm}, {Format: cc.FormatWasm, OptLevel: 1}, {Format: cc.FormatWasm, OptLevel: 2}} {
+		bin := new(bytes.Buffer)
+		err = cc.CompileWithOptions(bin, []rune(s), opts)
+		if err != nil {
+			a.t.Errorf("Compile to binary failed, options: %+v, error:\n%s\ncode: %s", opts, err.Error(), s)
+			return
+		}
+		a.run(expected, s, bin.Bytes(), funcs)
+	}
+}
+
+// CompileError checks that s is rejected with an error containing msg.
+func (a Assert) CompileError(msg string, s string) {
+	err := cc.Compile(new(strings.Builder), []rune(s))
+	if err == nil {
+		a.t.Errorf("Compile error expected: %s, code: %s", msg, s)
+		return
+	}
+	if !strings.Contains(err.Error(), msg) {
+		a.t.Errorf("Compile error expected: %s, got:\n%s\ncode: %s", msg, err.Error(), s)
+	}
+}
+
+func (a Assert) run(expected interface{}, s string, wasm []byte, funcs map[string]interface{}) {
 	store := wasmtime.NewStore(wasmtime.NewEngine())
 	module, err := wasmtime.NewModule(store.Engine, wasm)
 	if err != nil {
 		a.t.Errorf("Create Wasm module failed, error:\n%s\ncode: %s", err.Error(), s)
+		return
 	}
-	instance, err := wasmtime.NewInstance(store, module, nil)
+	linker := wasmtime.NewLinker(store.Engine)
+	for key, f := range funcs {
+		names := strings.SplitN(key, ".", 2)
+		if err := linker.FuncWrap(names[0], names[1], f); err != nil {
+			a.t.Fatalf("Define host function %s failed, error:\n%s", key, err.Error())
+		}
+	}
+	instance, err := linker.Instantiate(store, module)
 	if err != nil {
 		a.t.Errorf("Create Wasm instance failed, error:\n%s\ncode: %s", err.Error(), s)
+		return
 	}
 	run := instance.GetExport(store, "main").Func()
 	result, err := run.Call(store)
 	if err != nil {
 		a.t.Errorf("Run Wasm instance failed, error:\n%s\ncode: %s", err.Error(), s)
+		return
+	}
+	if v, ok := expected.(int); ok {
+		expected = int32(v)
 	}
 	if result != expected {
 		fmt.Printf("%v, %v\n", reflect.TypeOf(result), reflect.TypeOf(expected))
@@ -0,0 +1,30 @@
+package tests
+
+import "testing"
+
+func TestCast(t *testing.T) {
+	a := Assert{t: t}
+	a.Eval(int32(44), "int main() { return (char)300; }")
+	a.Eval(int32(44), "int main() { char c = 300; return c; }")
+	a.Eval(int32(-56), "int main() { return (short)65480; }")
+	a.Eval(int32(3), "int main() { long l = 3; return (int)(l * 1000000000 / 1000000000); }")
+	a.Eval(int32(1), "int main() { long l = 5; int i = 5; return l == i; }")
+	a.Eval(int32(0), "int main() { long l = 4294967296; return l == 0; }")
+	a.Eval(int32(5), "int main() { long a[3]; a[1] = 5; long l = 1; int x = *(a + l); return x; }")
+
+	a.Eval(int32(1), "int main() { _Bool b = 5; return b; }")
+	a.Eval(int32(1), "int main() { _Bool b = 256; return b; }")
+	a.Eval(int32(1), "int main() { long l = 4294967296; _Bool b = l; return b; }")
+	a.Eval(int32(2), "int main() { int x; int *p = &x; _Bool b = p; return b + sizeof(b); }")
+	a.Eval(int32(0), "int main() { int *p = 0; _Bool b = p; return b; }")
+	a.Eval(int32(1), "int main() { _Bool b = 1; b = b + 1; return b; }")
+	a.Eval(int32(1), "int main() { return (_Bool)-7 + (_Bool)0; }")
+	a.Eval(int32(0), "int main() { _Bool b = 0; return -b; }")
+	a.Eval(int32(5), "int main() { _Bool b[4]; b[2] = 9; return sizeof(b) + b[2]; }")
+	a.Eval(int32(10), "int main() { bool b = true; bool c = false; return b*10 + c; }")
+	a.Eval(int32(2), "int main() { return (bool)5 + sizeof(bool); }")
+	a.Eval(int32(5), "int main() { int true = 5; return true + false; }")
+	a.Eval(int32(4), "int main() { bool b = 2; { int bool = 3; return b + bool; } }")
+	a.Eval(int32(7), "int false(int x) { return x; } int main() { return false(7); }")
+	a.Eval(int32(3), "int main() { struct { _Bool a:1; _Bool b:1; } s; s.a = 1; s.b = 7; return s.a + s.b * 2; }")
+}
@@ -23,4 +23,8 @@ func TestControl(t *testing.T) {
 	a.Eval(int32(3), "int main() { return (1,2,3); }")
 	a.Eval(int32(5), "int main() { int i=2, j=3; (i=5,j)=6; return i; }")
 	a.Eval(int32(6), "int main() { int i=2, j=3; (i=5,j)=6; return j; }")
+
+	a.Eval(int32(1), "int main() { long l = 4294967296; if (l) return 1; return 0; }")
+	a.Eval(int32(2), "int main() { long l = 4294967296; int n = 0; for (; l; l = l - 2147483648) n = n + 1; return n; }")
+	a.Eval(int32(3), "int main() { int x; int *p = &x; if (p) return 3; return 4; }")
 }
@@ -0,0 +1,62 @@
+package tests
+
+import (
+	"cc/cc"
+	"github.com/bytecodealliance/wasmtime-go"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestStatic(t *testing.T) {
+	a := Assert{t: t}
+	a.Eval(int32(7), "static int h(int x) { static int n = 2; n = n + x; return n; } int main() { return h(1) + h(1); }")
+	a.Eval(int32(5), "static int n = 5; int main() { return n; }")
+	a.Eval(int32(3), "int main() { static int a[3] = {1, 2}; int s = a[0] + a[1]; a[0] = 0; return s; }")
+	a.Eval(int32(12), "int f() { static int n; n = n + 1; return n; } int g() { static int n = 10; return n; } int main() { f(); return f() + g(); }")
+	a.Eval(int32(2), "static int f(); int main() { return f(); } int f() { return 2; }")
+}
+
+func TestExport(t *testing.T) {
+	src := `int g; static int s; long h __attribute__((export_name("hh")));
+static int helper(int x) { return x; }
+int api(int x) { return helper(x); }
+__attribute__((export_name("entry"))) static int start() { return 0; }
+int main() { return api(1); }`
+
+	exports := func(opts *cc.Options) []string {
+		sb := new(strings.Builder)
+		if err := cc.CompileWithOptions(sb, []rune(src), opts); err != nil {
+			t.Fatalf("Compile failed, error:\n%s", err.Error())
+		}
+		wasm, err := wasmtime.Wat2Wasm(sb.String())
+		if err != nil {
+			t.Fatalf("Wat2Wasm failed, error:\n%s", err.Error())
+		}
+		module, err := wasmtime.NewModule(wasmtime.NewEngine(), wasm)
+		if err != nil {
+			t.Fatalf("Create Wasm module failed, error:\n%s", err.Error())
+		}
+		var names []string
+		for _, e := range module.Type().Exports() {
+			names = append(names, e.Name())
+		}
+		sort.Strings(names)
+		return names
+	}
+
+	cases := []struct {
+		opts     *cc.Options
+		expected []string
+	}{
+		{&cc.Options{}, []string{"api", "entry", "hh", "main", "memory"}},
+		{&cc.Options{Exports: []string{"main", "g", cc.StackPointerExport}}, []string{"__stack_pointer", "entry", "g", "hh", "main", "memory"}},
+		{&cc.Options{ExportAll: true}, []string{"__stack_pointer", "api", "entry", "g", "helper", "hh", "main", "memory"}},
+	}
+	for _, c := range cases {
+		if got := exports(c.opts); !reflect.DeepEqual(got, c.expected) {
+			t.Errorf("Exports error, options: %+v, expected: %v, got: %v", c.opts, c.expected, got)
+		}
+	}
+}
@@ -10,4 +10,38 @@ func TestFunction(t *testing.T) {
 	a.Eval(int32(55), "int main() { return fib(9); } int fib(int x) { if (x<=1) return 1; return fib(x-1) + fib(x-2); }")
 	a.Eval(int32(21), "int main() { return add6(1,2,3,4,5,6); } int add6(int a, int b, int c, int d, int e, int f) {return a+b+c+d+e+f;}")
 	a.Eval(int32(1), "int ret1(); int main() { return ret1(); } int ret1() { return 1; }")
+
+	a.Eval(int32(7), "int add(int a, int b) { return a+b; } int main() { int (*fp)(int, int) = add; return fp(3, 4); }")
+	a.Eval(int32(7), "int add(int a, int b) { return a+b; } int main() { int (*fp)(int, int) = &add; return (*fp)(3, 4); }")
+	a.Eval(int32(8), "int twice(int x) { return x*2; } int main() { return (**twice)(4); }")
+	a.Eval(int32(9), "int add(int a, int b) { return a+b; } int sub(int a, int b) { return a-b; } int apply(int (*f)(int, int), int a, int b) { return f(a, b); } int main() { return apply(sub, 10, 3) + apply(add, 1, 1); }")
+	a.Eval(int32(12), "int one() { return 1; } int two() { return 2; } int main() { int (*fs[2])(); fs[0]=one; fs[1]=two; return fs[0]()*10 + fs[1](); }")
+	a.Eval(int32(42), "int twice(int x) { return x*2; } int main() { struct { int (*f)(int); } o; o.f = twice; return o.f(21); }")
+	a.Eval(int32(1), "int twice(int x) { return x*2; } int main() { int (*f)(int) = twice; int (*g)(int) = &twice; return f == g; }")
+	a.Eval(int32(4), "int main() { int (*fp)(int); return sizeof(fp); }")
+
+	a.Eval(int32(10), "int sum(int n, ...) { va_list ap; va_start(ap, n); int s=0; int i; for (i=0; i<n; i=i+1) s=s+va_arg(ap, int); va_end(ap); return s; } int main() { return sum(4, 1, 2, 3, 4); }")
+	a.Eval(int32(0), "int sum(int n, ...) { va_list ap; va_start(ap, n); int s=0; int i; for (i=0; i<n; i=i+1) s=s+va_arg(ap, int); va_end(ap); return s; } int main() { return sum(0); }")
+	a.Eval(int32(98), "int second(char *fmt, ...) { va_list ap; va_start(ap, fmt); va_arg(ap, char); char *s = va_arg(ap, char *); return s[1]; } int main() { char c=7; return second(\"\", c, \"abc\"); }")
+	a.Eval(int32(12), "int vsum(int n, va_list ap) { int s=0; for (; n; n=n-1) s=s+va_arg(ap, int); return s; } int sum(int n, ...) { va_list ap; va_start(ap, n); va_list aq; va_copy(aq, ap); return vsum(n, ap) + vsum(n, aq); } int main() { return sum(3, 1, 2, 3); }")
+	a.Eval(int32(50), "int sum(int n, ...) { __builtin_va_list ap; __builtin_va_start(ap, n); return __builtin_va_arg(ap, int) * 10; } int main() { int (*f)(int, ...) = sum; return f(1, 5); }")
+
+	a.Eval(int32(3), "int f(long x) { return (int)x; } int main() { return f(3); }")
+	a.Eval(int32(3), "int main() { return f(3); } int f(long x) { return (int)x; }")
+	a.Eval(int32(44), "int f(char c) { return c; } int main() { return f(300); }")
+	a.Eval(int32(1), "int f(_Bool b) { return b; } int main() { return f(256); }")
+	a.Eval(int32(5), "int f(int a[]) { return a[1]; } int main() { int x[2]; x[1]=5; return f(x); }")
+	a.Eval(int32(6), "int f(int a[2][3]) { return sizeof(a) + sizeof(a[0]) / 6; } int main() { int x[2][3]; return f(x); }")
+	a.Eval(int32(8), "int twice(int x) { return x*2; } int apply(int f(int), int x) { return f(x); } int main() { return apply(twice, 4); }")
+	a.Eval(int32(1), "int f(int *p) { return p == 0; } int main() { return f(0); }")
+	a.Eval(int32(4), "int f(); int f(int a) { return a; } int main() { return f(4); }")
+	a.Eval(int32(3), "int f(int fp) { int x; int *p=&x; *p=fp; return x; } int main() { return f(3); }")
+
+	a.Eval(int32(5), "long f() { return 5000000000; } int main() { return f() / 1000000000; }")
+	a.Eval(int32(6), "long f(long x) { return x * 3; } int main() { long (*g)(long) = f; return g(2000000000) / 1000000000; }")
+	a.Eval(int32(3), "long f() { return 3; } int main() { return f(); }")
+	a.Eval(int32(1), "int f() { long x = 4294967297; return x; } int main() { return f(); }")
+	a.Eval(int32(1), "char f(int x) { return x; } int main() { return f(257); }")
+	a.Eval(int32(-1), "short f(int x) { return x; } int main() { return f(65535); }")
+	a.Eval(int32(1), "_Bool f(long x) { return x; } int main() { return f(4294967296); }")
 }
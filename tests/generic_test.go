@@ -0,0 +1,16 @@
+package tests
+
+import "testing"
+
+func TestGeneric(t *testing.T) {
+	a := Assert{t: t}
+	a.Eval(int32(2), "int main() { return _Generic(1, char: 1, int: 2, long: 3); }")
+	a.Eval(int32(3), "int main() { long l; return _Generic(l, char: 1, int: 2, long: 3); }")
+	a.Eval(int32(1), "int main() { char c; return _Generic(c, char: 1, int: 2, default: 9); }")
+	a.Eval(int32(2), "int main() { char c; return _Generic(c + c, char: 1, int: 2, default: 9); }")
+	a.Eval(int32(4), "int main() { int a[3]; return _Generic(a, int *: 4, int[3]: 5, default: 9); }")
+	a.Eval(int32(9), "int main() { int *p; return _Generic(p, char *: 1, long *: 2, default: 9); }")
+	a.Eval(int32(6), "int f(int x) { return x; } int main() { return _Generic(f, int (*)(int): 6, default: 9); }")
+	a.Eval(int32(2), "int main() { struct S { int x; } s; struct T { int x; } t; return _Generic(t, struct S: 1, struct T: 2); }")
+	a.Eval(int32(13), "int main() { int x = 3; return _Generic(x, int: x, default: 0) + _Generic((_Bool)x, _Bool: 10, int: 20); }")
+}
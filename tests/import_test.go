@@ -0,0 +1,43 @@
+package tests
+
+import (
+	"github.com/bytecodealliance/wasmtime-go"
+	"testing"
+)
+
+func TestImport(t *testing.T) {
+	a := Assert{t: t}
+	twice := map[string]interface{}{
+		"env.twice": func(x int32) int32 { return x * 2 },
+	}
+	a.EvalWithHost(int32(42), "int twice(int x); int main() { return twice(21); }", twice)
+	a.EvalWithHost(int32(42), "int main() { return twice(21); }", twice)
+	a.EvalWithHost(int32(14), "int twice(int x); int main() { int (*f)(int) = twice; return f(7); }", twice)
+	a.EvalWithHost(int32(6), "int twice(int x); int unused(int x); int main() { return twice(3); }", twice)
+	a.EvalWithHost(int32(3), "int twice(int x); int twice(int x) { return x + 1; } int main() { return twice(2); }", nil)
+	a.Eval(int32(4), "int main() { return sizeof(f(1)); }")
+	a.Eval(int32(8), "int g(int x); int main() { return sizeof(g(1)) + _Alignof(g(2)); }")
+	a.Eval(int32(8), "struct P { int x; int y; }; int h(int x); int main() { return sizeof((struct P){h(1), 2}); }")
+
+	a.EvalWithHost(int32(5), `long add(long a, char b) __attribute__((import_module("math"), import_name("add64"))); int main() { return add(4000000000, 5) - 4000000000; }`, map[string]interface{}{
+		"math.add64": func(a int64, b int32) int64 { return a + int64(b) },
+	})
+	a.EvalWithHost(int32(7), `__attribute__((import_name("seven"))) int f(); int main() { return f(); }`, map[string]interface{}{
+		"env.seven": func() int32 { return 7 },
+	})
+	a.EvalWithHost(int32(6), "int twice(); int g(int x) { return x; } int main() { if (g(1)) return twice(3); return 0; }", twice)
+	a.EvalWithHost(int32(8), "int twice(); int main() { char c = 4; return twice(c); }", twice)
+	a.CompileError("conflicting argument types in calls to 'twice'", "int twice(); int main() { return twice(1) + twice(2, 3); }")
+
+	// Strings are passed as addresses into the exported memory.
+	a.EvalWithHost(int32(3), `int strlen(char *s); int main() { return strlen("abc"); }`, map[string]interface{}{
+		"env.strlen": func(c *wasmtime.Caller, s int32) int32 {
+			mem := c.GetExport("memory").Memory().UnsafeData(c)
+			n := int32(0)
+			for mem[s+n] != 0 {
+				n++
+			}
+			return n
+		},
+	})
+}
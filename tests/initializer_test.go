@@ -0,0 +1,55 @@
+package tests
+
+import "testing"
+
+func TestInitializer(t *testing.T) {
+	a := Assert{t: t}
+	a.Eval(int32(321), "int main() { int x[3]={1,2,3}; return x[0]+x[1]*10+x[2]*100; }")
+	a.Eval(int32(6), "int main() { int x[2][3]={{1,2,3},{4,5,6}}; return x[1][2]; }")
+	a.Eval(int32(0), "int main() { int x[3]={}; return x[0]+x[1]+x[2]; }")
+	a.Eval(int32(20), "int main() { int x[2][3]={{1,2}}; return x[0][1]*10+x[1][2]; }")
+	a.Eval(int32(3), "int main() { int x[2][2]={1,2,3,4}; return x[1][0]; }")
+	a.CompileError("excess elements in initializer", "int main() { int x[2] = {1,2,3}; return x[1]; }")
+	a.CompileError("excess elements in initializer", "int main() { struct { int x; } s = {1,2}; return s.x; }")
+	a.CompileError("excess elements in initializer", "int main() { union { int a; char b; } u = {1, 2}; return u.a; }")
+	a.CompileError("excess elements in initializer", "int g[2][2] = {{1, 2}, {3, 4}, {5}}; int main() { return 0; }")
+	a.Eval(int32(16), "int main() { int x[]={1,2,3,4,}; return sizeof(x); }")
+	a.Eval(int32(99), "int main() { char x[4]=\"abc\"; return x[2]; }")
+	a.Eval(int32(4), "int main() { char x[]=\"foo\"; return sizeof(x); }")
+	a.Eval(int32(3), "int main() { int i = {3}; return i; }")
+	a.Eval(int32(1), "int main() { _Bool b[2] = {5, 0}; return b[0]; }")
+
+	a.Eval(int32(123), "int main() { struct {int a; int b; int c;} x={1,2,3}; return x.a*100+x.b*10+x.c; }")
+	a.Eval(int32(2), "int main() { struct {int a[2];} x={{1,2}}; return x.a[1]; }")
+	a.Eval(int32(34), "int main() { struct {int a; char b;} x[2]={1,2,3,4}; return x[1].a*10+x[1].b; }")
+	a.Eval(int32(34), "int main() { struct { int a; struct { char c; int d; } in; int e; } x = {1, 2, 3, 4}; return x.in.d * 10 + x.e; }")
+	a.Eval(int32(4), "int main() { struct { int a; struct { char c; int d; } in; int e; } x = {1, {2}, 4}; return x.in.d * 10 + x.e; }")
+	a.Eval(int32(4), "int main() { union {int a; char b[4];} x={16909060}; return x.b[0]; }")
+	a.Eval(int32(2), "int main() { struct S {int a; int b;} x={1,2}; struct S y = x; return y.b; }")
+
+	a.Eval(int32(3), "int g[3] = {1,2,3}; int main() { return g[2]; }")
+	a.Eval(int32(14), "int x = 2 + 3 * 4; int main() { return x; }")
+	a.Eval(int32(61), "char s[] = \"hello\"; int main() { return sizeof(s) * 10 + s[1] - 100; }")
+	a.Eval(int32(5), "int x = 5; int *p = &x; int main() { return *p; }")
+	a.Eval(int32(98), "char *s = \"abc\"; int main() { return s[1]; }")
+	a.Eval(int32(3), "int a[3] = {1,2,3}; int *p = a + 2; int main() { return *p; }")
+	a.Eval(int32(7), "int f() { return 7; } int (*fp)() = f; int main() { return fp(); }")
+	a.Eval(int32(124), "struct { int a; long b; char c[3]; } g = {1, 2, \"xy\"}; int main() { return (int)(g.a + g.b + g.c[1]); }")
+	a.Eval(int32(1), "long l[2] = {1, 4294967296}; int main() { return l[1] == 4294967296; }")
+	a.Eval(int32(28), "struct B { int a:3; int b:5; }; struct B g = {3, -2}; int main() { return g.a * 10 + g.b; }")
+	a.Eval(int32(23), "struct B { int x; int y; }; struct A { struct B b; int c; }; int main() { struct B v = {1, 2}; struct A a[1] = { v, 3 }; return a[0].b.y * 10 + a[0].c; }")
+	a.Eval(int32(985), "struct S { char s[4]; int n; }; int main() { struct S x[1] = { \"ab\", 5 }; return x[0].s[1] * 10 + x[0].n; }")
+	a.Eval(int32(42), "struct P { int a; int b; }; int main() { struct P q = {4, 2}; struct P ps[2] = { (struct P){1, 3}, q }; return ps[1].a * 10 + ps[1].b; }")
+}
+
+func TestCompoundLiteral(t *testing.T) {
+	a := Assert{t: t}
+	a.Eval(int32(12), "int main() { struct P { int x; int y; }; struct P p = (struct P){3, 4}; return p.x * p.y; }")
+	a.Eval(int32(3), "int main() { return (int[]){1,2,3}[2]; }")
+	a.Eval(int32(5), "int main() { int *p = (int[]){4,5,6}; return p[1]; }")
+	a.Eval(int32(12), "int main() { return sizeof((int[]){1,2,3}); }")
+	a.Eval(int32(6), "int main() { return ((struct { int a; int b; }){5, 6}).b; }")
+	a.Eval(int32(3), "int main() { int s = 0; int i; for (i = 0; i < 3; i = i + 1) { int *p = (int[]){i, i}; s = s + p[1]; } return s; }")
+	a.Eval(int32(9), "int *p = (int[]){7,8,9}; int main() { return p[2]; }")
+	a.Eval(int32(2), "struct P { int x; int y; }; struct P *p = &(struct P){1, 2}; int main() { return p->y; }")
+}
@@ -0,0 +1,179 @@
+package tests
+
+import (
+	"cc/cc"
+	"strings"
+	"testing"
+)
+
+// irCase is a program whose IR must contain each of the expected strings
+// and none of the unexpected ones.
+type irCase struct {
+	src        string
+	expected   []string
+	unexpected []string
+}
+
+// checkIR compiles each case to IR with opts and checks the result.
+func checkIR(t *testing.T, opts *cc.Options, cases ...irCase) {
+	t.Helper()
+	for _, c := range cases {
+		sb := new(strings.Builder)
+		o := *opts
+		o.Format = cc.FormatIR
+		if err := cc.CompileWithOptions(sb, []rune(c.src), &o); err != nil {
+			t.Fatalf("Compile failed, source: %s, error:\n%s", c.src, err.Error())
+		}
+		ir := sb.String()
+		for _, e := range c.expected {
+			if !strings.Contains(ir, e) {
+				t.Errorf("IR error, source: %s, options: %+v, expected to contain: %q, got:\n%s", c.src, opts, e, ir)
+			}
+		}
+		for _, e := range c.unexpected {
+			if strings.Contains(ir, e) {
+				t.Errorf("IR error, source: %s, options: %+v, expected not to contain: %q, got:\n%s", c.src, opts, e, ir)
+			}
+		}
+	}
+}
+
+func TestIR(t *testing.T) {
+	checkIR(t, &cc.Options{},
+		irCase{"int main() { return 3; }", []string{"func main() i32 frame 0 {\nb0:\n", "= const 3", "ret %"}, nil},
+		irCase{"int f(int x) { return x; }", []string{"func f(%0 i32) i32 frame 0 {", "= copy %0\n"}, nil},
+		irCase{"long f(char *p) { return *p; }", []string{"func f(%0 i32) i64 frame 0 {", "= load.1 %", ":i64 = extend %"}, nil},
+		irCase{"int main() { int x = 1; int *p = &x; return *p; }", []string{"func main() i32 frame 16 {", "= frame 0 ; x\n", "store.4 %"}, nil},
+		irCase{"int f(int x) { if (x) return 1; return 2; }", []string{"br %", "jump b"}, nil},
+		irCase{"int g; int f(int x) { return g + f(x); }", []string{"= global @g", "= call @f, %"}, nil},
+	)
+}
+
+func TestFoldConstants(t *testing.T) {
+	simplified := []string{" mul ", " div ", "br %"}
+	checkIR(t, &cc.Options{OptLevel: 1},
+		irCase{"int main() { return sizeof(int) * 4 + 1; }", []string{"= const 17\n  ret %"}, simplified},
+		irCase{"int main() { int a[4]; return &a[3] - &a[1]; }", []string{"= const 12\n"}, simplified},
+		irCase{"int f(int x) { return x * 8; }", []string{"= const 3\n", "= shl %"}, simplified},
+		irCase{"int f(int x) { return x / 4; }", []string{"= shr %", "= shr_u %"}, simplified},
+		irCase{"long f(long x) { return x * 1 + 0; }", []string{":i64 = copy %"}, simplified},
+		irCase{"int main() { if (2 - 2) return 1; return 2; }", []string{"= const 2\n  ret %"}, simplified},
+	)
+
+	a := Assert{t: t}
+	a.Eval(int32(-1), "int f(int x) { return x / 4; } int main() { return f(-7); }")
+	a.Eval(int32(-2), "int f(int x) { return x / 4; } int main() { return f(-8); }")
+	a.Eval(int32(1), "int f(int x) { return x / 4; } int main() { return f(7); }")
+	a.Eval(int32(-3), "long f(long x) { return x / 2; } int main() { return f(-7); }")
+	a.Eval(int32(-24), "int f(int x) { return x * 8; } int main() { return f(-3); }")
+	a.Eval(int32(-2147483648), "int main() { return -2147483647 - 1 + 0 * 5; }")
+	a.Eval(int32(0), "int main() { int x = 5; return x - 5; }")
+	a.Eval(int32(6), "int f(int a) { int b = a; a = 5; return a + b; } int main() { return f(1); }")
+	a.Eval(int32(3), "static int clamp(int x) { if (x < 0) x = 0; return x; } int main() { return clamp(-5) + clamp(3); }")
+}
+
+func TestEliminateDeadCode(t *testing.T) {
+	checkIR(t, &cc.Options{OptLevel: 1},
+		irCase{"int main() { return 1; 2; 3; }", nil, []string{"const 3"}},
+		irCase{"int main() { int i; int s = 0; for (i = 0; i < 3; i = i + 1) if (0) s = 9; return s; }", nil, []string{"const 9"}},
+		irCase{"int main() { int x = 2; if (x == 2) return 5; else return 6; }", nil, []string{"const 6"}},
+		irCase{"int f(int x) { x * 4; if (x) { } return x; }", nil, []string{"mul"}},
+		irCase{"int main() { for (;;) { } return 1; }", nil, []string{"ret"}},
+		irCase{"int f(int x) { int i; for (i = 0; ; i = i + 1) { if (i == x) return i; } return -1; }", nil, []string{"const -1"}},
+	)
+
+	a := Assert{t: t}
+	a.Eval(int32(1), "int main() { return 1; 2; 3; }")
+	a.Eval(int32(0), "int main() { int i; int s = 0; for (i = 0; i < 3; i = i + 1) if (0) s = 9; return s; }")
+	a.Eval(int32(5), "int main() { int x = 2; if (x == 2) return 5; else return 6; }")
+	a.Eval(int32(7), "int f(int x) { x * 4; if (x) { } return x; } int main() { return f(7); }")
+	a.Eval(int32(3), "int main() { int i = 0; for (;;) { i = i + 1; if (i == 3) return i; } return -1; }")
+	a.Eval(int32(2), "int f(int x) { int i; for (i = 0; ; i = i + 1) { if (i == x) return i; } return -1; } int main() { return f(2); }")
+	a.Eval(int32(6), "int f(int x) { if (x > 0) { return x; } else { if (x < 0) return -x; } return 6; } int main() { return f(0); }")
+}
+
+func TestInline(t *testing.T) {
+	checkIR(t, &cc.Options{OptLevel: 2},
+		irCase{"static int sq(int x) { return x * x; } int main() { return sq(3); }", []string{"= const 9\n"}, []string{"call", "func sq"}},
+		irCase{"int get(int *p) { return *p; } int main() { int v = 3; return get(&v); }", []string{"func get", "func main() i32 frame 16 {"}, []string{"call"}},
+		irCase{"int fib(int x) { if (x <= 1) return 1; return fib(x - 1) + fib(x - 2); }", []string{"call @fib"}, nil},
+		irCase{"static int f(int x) { int a[2]; a[0] = x; a[1] = 2; return a[0] + a[1]; } int main() { int b[3]; return f(1) + f(2); }", []string{"func main() i32 frame 32 {"}, []string{"call", "func f"}},
+		irCase{"static int f(int x) { int a[2]; a[0] = x; a[1] = 2; return a[0] * a[1] + a[0] * a[1] + a[0] * a[1] + a[0] * a[1]; } int main() { int b[3]; return f(1); }", []string{"func main() i32 frame 32 {"}, []string{"call", "func f"}},
+	)
+
+	a := Assert{t: t}
+	a.Eval(int32(9), "static int sq(int x) { return x * x; } int main() { return sq(3); }")
+	a.Eval(int32(5), "static int f(int x) { int a[2]; a[0] = x; a[1] = 2; return a[0] + a[1]; } int main() { int b[3]; b[2] = 2; return f(1) + f(2) - b[2]; }")
+	a.Eval(int32(3), "static int f(int x) { return x; } int main() { int (*p)(int) = f; return f(1) + p(2); }")
+	a.Eval(int32(10), "int abs(int x) { if (x < 0) return -x; return x; } int main() { return abs(-4) + abs(6); }")
+	a.Eval(int32(5), "struct P { int x; int y; }; static int sum(struct P p) { return p.x + p.y; } int main() { struct P p; p.x = 2; p.y = 3; return sum(p); }")
+	a.Eval(int32(2), "int f(); int main() { return f(2); } int f(int x) { return x; }")
+	a.Eval(int32(12), "static int g(int x) { int i; int s = 0; for (i = 0; i < x; i = i + 1) s = s + i; return s; } static int h(int x) { return g(x) + 2; } int main() { return h(5); }")
+}
+
+func TestPasses(t *testing.T) {
+	src := "int twice(int x) { int y = x + x; int z = y - x; return y + z - x + 0; } int main() { return twice(2 * 3); }"
+	for _, c := range []struct {
+		opts       *cc.Options
+		expected   []string
+		unexpected []string
+	}{
+		{&cc.Options{}, []string{"call @twice", " add ", "= const 2\n"}, nil},
+		{&cc.Options{EnablePasses: []string{"constfold"}}, []string{"call @twice", "= const 6\n"}, nil},
+		{&cc.Options{OptLevel: 1}, []string{"call @twice", "= const 6\n"}, []string{"= const 2\n"}},
+		{&cc.Options{OptLevel: 1, DisablePasses: []string{"constfold"}}, []string{"= const 2\n"}, nil},
+		{&cc.Options{OptLevel: 2}, []string{"func twice"}, []string{"call @twice"}},
+		{&cc.Options{OptLevel: 2, OptSize: true}, []string{"call @twice"}, nil},
+		{&cc.Options{OptLevel: 2, DisablePasses: []string{"inline"}}, []string{"call @twice"}, nil},
+	} {
+		checkIR(t, c.opts, irCase{src, c.expected, c.unexpected})
+	}
+
+	// printAfter returns what is printed after the passes when compiling.
+	printAfter := func(opts *cc.Options) string {
+		log := new(strings.Builder)
+		opts.Format, opts.PrintTo = cc.FormatIR, log
+		if err := cc.CompileWithOptions(new(strings.Builder), []rune(src), opts); err != nil {
+			t.Fatalf("Compile failed, options: %+v, error:\n%s", opts, err.Error())
+		}
+		return log.String()
+	}
+	if log := printAfter(&cc.Options{OptLevel: 2}); log != "" {
+		t.Errorf("Print after error, expected nothing, got:\n%s", log)
+	}
+	log := printAfter(&cc.Options{OptLevel: 2, PrintAfter: []string{"constfold", "inline"}})
+	if strings.Count(log, "; after constfold\nfunc twice") != 2 || strings.Count(log, "; after inline\n") != 1 {
+		t.Errorf("Print after error, got:\n%s", log)
+	}
+	log = printAfter(&cc.Options{OptLevel: 1, PrintAfter: []string{"all"}, PrintWat: true})
+	if strings.Count(log, "(module") != 3 || !strings.Contains(log, "; after dce\n(module") {
+		t.Errorf("Print after error, got:\n%s", log)
+	}
+
+	err := cc.CompileWithOptions(new(strings.Builder), []rune(src), &cc.Options{DisablePasses: []string{"bogus"}})
+	if err == nil || err.Error() != "unknown pass 'bogus'" {
+		t.Errorf("Expected an unknown pass error, got: %v", err)
+	}
+
+	a := Assert{t: t}
+	a.Eval(int32(12), src)
+}
+
+func TestControlFlow(t *testing.T) {
+	a := Assert{t: t}
+	a.Eval(int32(4), "int main() { int i; int j; int n = 0; for (i = 0; i < 3; i = i + 1) for (j = 0; j < i; j = j + 1) n = n + j + 1; return n; }")
+	a.Eval(int32(4), "int f(int x) { int i; for (i = 0; ; i = i + 1) { if (i == x) return i; } return -1; } int main() { return f(4); }")
+	a.Eval(int32(5), "int main() { int i; int n = 0; for (i = 0; i < 10; i = i + 1) { if (i < 5) n = n + 1; else if (i == 7) return n; } return -1; }")
+	a.Eval(int32(3), "int main() { int x = 1; if (x) { if (x == 2) x = 5; else x = 3; } else x = 4; return x; }")
+}
+
+func TestPromoteLocals(t *testing.T) {
+	a := Assert{t: t}
+	a.Eval(int32(-56), "int main() { char c; c = 200; return c; }")
+	a.Eval(int32(4464), "int main() { short s = 70000; return s; }")
+	a.Eval(int32(-56), "int f(char c) { c = c + 100; return c; } int main() { return f(100); }")
+	a.Eval(int32(1), "int main() { _Bool b; b = 2; return b; }")
+	a.Eval(int32(45), "int main() { long s = 0; int i; for (i = 0; i < 10; i = i + 1) s = s + i; return s; }")
+	a.Eval(int32(6), "int set(int *p, int v) { *p = v; return v; } int main() { int x = 1; int y = 2; set(&x, 4); return x + y; }")
+	a.Eval(int32(3), "int main() { int x = {3}; return x; }")
+}
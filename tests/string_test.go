@@ -49,6 +49,5 @@ func TestString(t *testing.T) {
 	a.Eval(int32(1), `int main() { char x; return sizeof(x); }`)
 	a.Eval(int32(10), `int main() { char x[10]; return sizeof(x); }`)
 
-	// FIXME
-	// a.Eval(int32(1), `int main() { return sub_char(7, 3, 3); } int sub_char(char a, char b, char c) { return a-b-c; }`)
+	a.Eval(int32(1), `int main() { return sub_char(7, 3, 3); } int sub_char(char a, char b, char c) { return a-b-c; }`)
 }
@@ -49,4 +49,60 @@ func TestStruct(t *testing.T) {
 
 	a.Eval(16, "int main() { struct {char a; long b;} x; return sizeof x; }")
 	a.Eval(4, "int main() { struct {char a; short b;} x; return sizeof x; }")
+
+	a.Eval(34, "struct pt {int x; int y;} g; int sum(struct pt p) { return p.x*10+p.y; } int main() { struct pt a; a.x=3; a.y=4; return sum(a); }")
+	a.Eval(3, "struct pt {int x; int y;} g; int clobber(struct pt p) { p.x=9; return p.x; } int main() { struct pt a; a.x=3; clobber(a); return a.x; }")
+	a.Eval(34, "struct pt {int x; int y;} g; struct pt mk(int x, int y) { struct pt p; p.x=x; p.y=y; return p; } int main() { struct pt a; a = mk(3, 4); return a.x*10+a.y; }")
+	a.Eval(6, "struct pt {int x; int y;} g; struct pt mk(int x, int y) { struct pt p; p.x=x; p.y=y; return p; } int main() { return mk(5, 6).y; }")
+	a.Eval(21, "struct pt {int x; int y;} g; struct pt mk(int x, int y) { struct pt p; p.x=x; p.y=y; return p; } struct pt swap(struct pt p) { return mk(p.y, p.x); } int main() { struct pt a = swap(mk(1, 2)); return a.x*10+a.y; }")
+	a.Eval(15, "struct pt {int x; int y;} g; struct pt mk(int x, int y) { struct pt p; p.x=x; p.y=y; return p; } int main() { struct pt (*f)(int, int) = mk; struct pt a, b; a = b = f(7, 8); return a.y+b.x; }")
+	a.Eval(42, "struct pt {int x; int y;} g; int vget(int n, ...) { va_list ap; va_start(ap, n); struct pt p = va_arg(ap, struct pt); return p.x + va_arg(ap, int); } int main() { struct pt a; a.x=40; return vget(1, a, 2); }")
+
+	a.Eval(4, "int main() { struct {int a:3; int b:5;} x; return sizeof(x); }")
+	a.Eval(2, "int main() { struct {char a:3; char b:6;} x; return sizeof(x); }")
+	a.Eval(8, "int main() { struct {int a:3; int :0; int b:1;} x; return sizeof(x); }")
+	a.Eval(4, "int main() { struct {char a; int b:4;} x; return sizeof(x); }")
+	a.Eval(2, "int main() { struct {char a; int :4;} x; return sizeof(x); }")
+	a.Eval(8, "int main() { struct {char a; long b:40; int c:3;} x; return sizeof(x); }")
+	a.Eval(28, "int main() { struct {int a:3; int b:5;} x; x.a=3; x.b=-2; return x.a*10+x.b; }")
+	a.Eval(-1, "int main() { struct {int a:2+1; int b:sizeof(int)*8-3;} x; x.a=7; x.b=-1; return x.a + (sizeof(x)-4)*100; }")
+	a.CompileError("negative width in bit-field 'a'", "int main() { struct {int a:1-2;} x; return 0; }")
+	a.Eval(-1, "int main() { struct {int a:3;} x; x.a=7; return x.a; }")
+	a.Eval(1, "int main() { struct {int a:3;} x; return x.a=9; }")
+	a.Eval(35, "int main() { struct {int a:3; int b:5; short c:7;} x; x.a=1; x.b=3; x.c=5; x.a=2; return x.b*10+x.c; }")
+	a.Eval(33, "int main() { union { struct {int a:4; int b:4;} s; char c[4]; } u; u.c[0]=0; u.s.a=1; u.s.b=2; return u.c[0]; }")
+	a.Eval(517, "int main() { union { struct {char a; long b:40; int c:3;} s; char c[8]; } u; u.s.a=0; u.s.b=261; u.s.c=-1; return u.c[1]*100+u.c[2]*10+u.c[6]; }")
+	a.Eval(1, "int main() { struct t {char a; long b:40; int c:3;} x; struct t *p=&x; p->b=5; p->c=1; return p->c; }")
+
+	a.Eval(123, "struct node { int v; struct node *next; }; int main() { struct node a, b, c; a.v=1; b.v=2; c.v=3; a.next=&b; b.next=&c; c.next=0; int s=0; struct node *p; for (p=&a; p; p=p->next) s=s*10+p->v; return s; }")
+	a.Eval(6, "struct tree; int sum(struct tree *t); struct tree { int v; struct tree *l; struct tree *r; }; int sum(struct tree *t) { if (t == 0) return 0; return t->v + sum(t->l) + sum(t->r); } int main() { struct tree a, b, c; a.v=1; b.v=2; c.v=3; a.l=&b; a.r=&c; b.l=b.r=c.l=c.r=0; return sum(&a); }")
+	a.Eval(45, "struct b; struct a { struct b *pb; int x; }; struct b { struct a *pa; int y; }; int main() { struct a x; struct b y; x.pb=&y; y.pa=&x; x.x=4; y.y=5; return x.pb->pa->x*10 + x.pb->y; }")
+	a.Eval(8, "int main() { struct foo *p; struct foo {int a; int b;}; struct foo x; p=&x; return sizeof(*p); }")
+	a.Eval(1, "int main() { struct t {int a;}; { struct t; struct t *p; struct t {char c;}; struct t x; p=&x; return sizeof(*p); } }")
+	a.Eval(4, "int main() { struct t {int a;}; { struct t *p; struct t x; p=&x; return sizeof(*p); } }")
+
+	a.Eval(4, "int main() { struct msg {int len; char data[];} x; return sizeof(x); }")
+	a.Eval(4, "int main() { struct msg {char tag; int data[];} x; return sizeof(x); }")
+	a.Eval(16, "int main() { struct msg {long a; char c; char data[];} x; return sizeof(x); }")
+	a.Eval(4, "int main() { struct msg {int len; char data[0];} x; return sizeof(x); }")
+	a.Eval(603, "struct msg {int len; char data[];}; int sum(struct msg *m) { int s=0; int i; for (i=0; i<m->len; i=i+1) s=s+m->data[i]; return s; } int main() { char buf[16]; struct msg *m=buf; m->len=3; m->data[0]=1; m->data[1]=2; m->data[2]=3; return sum(m)*100 + buf[4+2]; }")
+	a.Eval(8, "int main() { struct msg {int len; int data[];} *m; char buf[32]; m=buf; m->data[5]=7; return *(m->data+5) + (&m->data[1] - m->data); }")
+
+	a.Eval(int32(24), "int main() { struct { char a; long b; } s; return _Alignof(s) + sizeof(s); }")
+	a.Eval(int32(15), "int main() { struct __attribute__((packed)) { char a; int b; } s; return sizeof(s) + _Alignof(s)*10; }")
+	a.Eval(int32(57), "int main() { struct S { char a; int b; } __attribute__((packed)); struct S s; s.b = 7; s.a = 1; return s.b + sizeof(struct S)*10; }")
+	a.Eval(int32(1616), "int main() { struct { char a; int b; } __attribute__((aligned(16))) s; return sizeof(s) + _Alignof(s)*100; }")
+	a.Eval(int32(16), "int main() { struct { char a; _Alignas(8) char b; } s; return sizeof(s); }")
+	a.Eval(int32(8), "int main() { struct { char a; char b __attribute__((aligned(4))); } s; return sizeof(s); }")
+	a.Eval(int32(68), "#pragma pack(push, 2)\nstruct S { char a; int b; };\n#pragma pack(pop)\nstruct T { char a; int b; };\nint main() { return sizeof(struct S) * 10 + sizeof(struct T); }")
+	a.Eval(int32(9), "#pragma pack(1)\nstruct S { char a; long b; };\n#pragma pack()\nint main() { return sizeof(struct S); }")
+
+	a.Eval(int32(22), "int main() { struct { int tag; union { int i; char c; }; } v; v.i = 1; v.tag = 2; return v.i + v.c + v.tag * 10; }")
+	a.Eval(int32(44), "int main() { struct { char a; struct { int b; long c; }; int d; } v; v.b = 3; v.c = 4; v.d = 5; return sizeof(v) + v.b + (int)v.c + v.d; }")
+	a.Eval(int32(18), "int main() { struct { int t; union { struct { int x; int y; }; long z; }; } v; v.x = 1; v.y = 2; return v.y + sizeof(v); }")
+	a.Eval(int32(321), "int main() { struct { int t; struct { int x; int y; }; } v = {1, {2, 3}}; return v.t + v.x * 10 + v.y * 100; }")
+	a.Eval(int32(4), "int main() { struct { int a; struct T { int b; }; } v; return sizeof(v); }")
+	a.CompileError("duplicate member 'a'", "int main() { struct { int a; struct { int a; }; } v; return 0; }")
+	a.CompileError("duplicate member 'b'", "int main() { struct { union { int b; }; struct { char c; union { int b; }; }; } v; return 0; }")
+	a.CompileError("duplicate member 'a'", "int main() { struct { int a; char a; } v; return 0; }")
 }
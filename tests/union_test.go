@@ -15,4 +15,9 @@ func TestUnion(t *testing.T) {
 
 	a.Eval(3, "int main() { union {int a,b;} x,y; x.a=3; y.a=5; y=x; return y.a; }")
 	a.Eval(3, "int main() { union {struct {int a,b;} c;} x,y; x.c.b=3; y.c.b=5; y=x; return y.c.b; }")
+
+	a.Eval(2, "union u {int i; char c[4];} g; union u mk(int i) { union u v; v.i=i; return v; } int main() { return mk(515).c[1]; }")
+
+	a.Eval(int32(21), "int main() { union { struct { char lo; char hi; }; short w; } u; u.w = 258; return u.lo * 10 + u.hi; }")
+	a.Eval(int32(9), "int main() { struct { int a; union { int b : 3; int c : 5; }; } v; v.c = 9; return v.c; }")
 }
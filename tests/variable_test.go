@@ -20,12 +20,13 @@ func TestVariable(t *testing.T) {
 
 	a.Eval(int32(3), "int main() { int x=3; return *&x; }")
 	a.Eval(int32(3), "int main() { int x=3; int *y=&x; int **z=&y; return **z; }")
-	a.Eval(int32(5), "int main() { int x=3; int y=5; return *(&x+1); }")
-	a.Eval(int32(3), "int main() { int x=3; int y=5; return *(&y-1); }")
-	a.Eval(int32(5), "int main() { int x=3; int y=5; return *(&x-(-1)); }")
+	// Locals are adjacent in the frame as long as their address is taken.
+	a.Eval(int32(5), "int main() { int x=3; int y=5; int *p=&y; return *(&x+1); }")
+	a.Eval(int32(3), "int main() { int x=3; int y=5; int *p=&x; return *(&y-1); }")
+	a.Eval(int32(5), "int main() { int x=3; int y=5; int *p=&y; return *(&x-(-1)); }")
 	a.Eval(int32(5), "int main() { int x=3; int *y=&x; *y=5; return x; }")
-	a.Eval(int32(7), "int main() { int x=3; int y=5; *(&x+1)=7; return y; }")
-	a.Eval(int32(7), "int main() { int x=3; int y=5; *(&y-2+1)=7; return x; }")
+	a.Eval(int32(7), "int main() { int x=3; int y=5; int *p=&y; *(&x+1)=7; return y; }")
+	a.Eval(int32(7), "int main() { int x=3; int y=5; int *p=&x; *(&y-2+1)=7; return x; }")
 	a.Eval(int32(5), "int main() { int x=3; return (&x+2)-&x+3; }")
 	a.Eval(int32(8), "int main() { int x, y; x=3; y=5; return x+y; }")
 	a.Eval(int32(8), "int main() { int x=3, y=5; return x+y; }")
@@ -68,8 +69,8 @@ func TestVariable(t *testing.T) {
 	a.Eval(int32(2), "int main() { int x=2; { int x=3; } { int y=4; return x; }}")
 	a.Eval(int32(3), "int main() { int x=2; { x=3; } return x; }")
 
-	a.Eval(int32(1), "int main() { int x; int y; char z; char *a=&y; char *b=&z; return b-a; }")
-	a.Eval(int32(7), "int main() { int x; char y; int z; char *a=&y; char *b=&z; return b-a; }")
+	a.Eval(int32(4), "int main() { int x; int y; char z; char *a=&y; char *b=&z; return b-a; }")
+	a.Eval(int32(4), "int main() { int x; char y; int z; char *a=&y; char *b=&z; return b-a; }")
 
 	a.Eval(int32(8), "int main() { long x; return sizeof(x); }")
 	a.Eval(int32(2), "int main() { short x; return sizeof(x); }")
@@ -81,7 +82,37 @@ func TestVariable(t *testing.T) {
 	a.Eval(int32(12), "int main() { char (x[3])[4]; return sizeof(x); }")
 	a.Eval(int32(4), "int main() { char (x[3])[4]; return sizeof(x[0]); }")
 
+	a.Eval(int32(16), "int main() { int n=4; int a[n]; return sizeof(a); }")
+	a.Eval(int32(20), "int main() { int n=5; int a[n]; int i; for (i=0; i<n; i=i+1) a[i]=i*i; return a[4]+a[2]; }")
+	a.Eval(int32(43), "int main() { int n=2; int a[n][3]; a[1][2]=7; return a[1][2] + sizeof(a) + sizeof(a[0]); }")
+	a.Eval(int32(80), "int main() { int n=4; { int a[n]; { long b[n*2]; return sizeof(b)+sizeof(a); } } }")
+	a.Eval(int32(499500), "int main() { int i; int s=0; for (i=0; i<1000; i=i+1) { int a[i+1000]; a[i]=i; s=s+a[i]; } return s; }")
+	a.Eval(int32(112), "int f(int n) { int a[n]; a[n-1]=n; return a[n-1]; } int main() { int x=2; int r=f(10)+f(100); return r+x; }")
+	a.Eval(int32(12), "int g[1+2]; int main() { return sizeof(g); }")
+	a.Eval(int32(8), "int main() { struct { int a[1+1]; } s; return sizeof(s); }")
+	a.Eval(int32(24), "int main() { int a[2*3]; _Static_assert(sizeof(a) == 24, \"x\"); return sizeof(a); }")
+	a.CompileError("size of array is negative", "int main() { int a[1-2]; return 0; }")
+	// Only the outermost dimension may have a variable length.
+	a.CompileError("arrays of variable length arrays are not supported", "int main() { int n=2; int a[n][n]; return 0; }")
+	a.CompileError("arrays of variable length arrays are not supported", "int main() { int n=2; int a[3][n]; return 0; }")
+	a.Eval(int32(7), "int main() { char *p=__builtin_alloca(10); p[9]=3; int x=4; return p[9]+x; }")
+	a.Eval(int32(5), "int main() { int *p=alloca(8); int *q=alloca(8); p[1]=2; q[1]=3; return p[1]+q[1]; }")
+
 	// FIXME
 	//a.Eval(int32(3), "int main() { char *x[3]; char y; x[0]=&y; y=3; return x[0][0]; }")
 	//a.Eval(int32(4), "int main() { char x[3]; char (*y)[3]=x; y[0][0]=4; return y[0][0]; }")
+
+	a.Eval(int32(184), "int main() { return _Alignof(int) + _Alignof(long)*10 + _Alignof(char)*100; }")
+	a.Eval(int32(17), "int main() { return sizeof(int[3]) + sizeof(int) + sizeof(struct { char c; }); }")
+	a.Eval(int32(8), "int main() { _Alignas(long) char x; return _Alignof(x); }")
+	a.Eval(int32(8), "int main() { int x __attribute__((unused, aligned(8))); return _Alignof(x); }")
+	a.Eval(int32(1), "int main() { char c; _Alignas(16) char x; char d; int a = (int)&x; return a - a/16*16 + sizeof(x); }")
+	a.Eval(int32(0), "char h; _Alignas(16) char g; int main() { int a = (int)&g; return a - a/16*16; }")
+	a.Eval(int32(35), "int f(int n) { char d; _Alignas(64) char c; int a = (int)&c; return a - a/64*64 + n; } int main() { int x = 5; int *p = &x; int r = f(1) + f(2); return r*10 + *p; }")
+	a.Eval(int32(14), "int g(int n) { _Alignas(32) char c; int v[n]; v[0] = n; int a = (int)&c; return a - a/32*32 + v[0]; } int main() { int x = 7; int *p = &x; return g(3) + g(4) + *p; }")
+
+	a.Eval(int32(3), "_Static_assert(sizeof(int) == 4, \"int is 4 bytes\"); int main() { _Static_assert(sizeof(long) == 8); return 3; }")
+	a.Eval(int32(8), "struct S { char c; _Static_assert(1, \"x\"); int i; }; static_assert(sizeof(struct S) == 8, \"S\"); int main() { return sizeof(struct S); }")
+	a.Eval(int32(3), "int main() { int static_assert = 3; return static_assert; }")
+	a.Eval(int32(4), "int static_assert(int x) { return x + 1; } int main() { static_assert(2); return static_assert(3); }")
 }
@@ -0,0 +1,90 @@
+package tests
+
+import (
+	"bytes"
+	"cc/cc"
+	"github.com/bytecodealliance/wasmtime-go"
+	"strings"
+	"testing"
+)
+
+// TestWasmModule writes a module built by hand in both formats.
+func TestWasmModule(t *testing.T) {
+	m := &cc.WasmModule{
+		Memory:  &cc.WasmMemory{Pages: 1, Export: "memory"},
+		Globals: []*cc.WasmGlobal{{Name: "g", Type: "i64", Mutable: true, Init: -5}},
+		Data:    []*cc.WasmData{{Offset: 8, Bytes: []byte{7, 0, 0, 0}}},
+		Funcs: []*cc.WasmFunc{{
+			Name:    "f",
+			Export:  "f",
+			Params:  []*cc.WasmLocal{{Name: "x", Type: "i32"}},
+			Results: []string{"i64"},
+			Locals:  []*cc.WasmLocal{{Name: "y", Type: "i32"}},
+			Body: []*cc.Instr{
+				{Op: "i32.const", Val: 4},
+				{Op: "i32.load", Val: 4},
+				{Op: "local.get", Name: "x"},
+				{Op: "i32.add"},
+				{Op: "local.set", Name: "y"},
+				{Op: "block", Name: "B"},
+				{Op: "local.get", Name: "y"},
+				{Op: "br_if", Name: "B"},
+				{Op: "unreachable"},
+				{Op: "end"},
+				{Op: "local.get", Name: "y"},
+				{Op: "i64.extend_i32_s"},
+				{Op: "global.get", Name: "g"},
+				{Op: "i64.add"},
+			},
+		}},
+	}
+
+	sb := new(strings.Builder)
+	if err := cc.WriteWat(sb, m); err != nil {
+		t.Fatalf("WriteWat failed, error:\n%s", err.Error())
+	}
+	text, err := wasmtime.Wat2Wasm(sb.String())
+	if err != nil {
+		t.Fatalf("Wat2Wasm failed, error:\n%s\ntext: %s", err.Error(), sb.String())
+	}
+	bin := new(bytes.Buffer)
+	if err := cc.WriteWasm(bin, m); err != nil {
+		t.Fatalf("WriteWasm failed, error:\n%s", err.Error())
+	}
+
+	for _, wasm := range [][]byte{text, bin.Bytes()} {
+		store := wasmtime.NewStore(wasmtime.NewEngine())
+		module, err := wasmtime.NewModule(store.Engine, wasm)
+		if err != nil {
+			t.Fatalf("Create Wasm module failed, error:\n%s", err.Error())
+		}
+		instance, err := wasmtime.NewInstance(store, module, nil)
+		if err != nil {
+			t.Fatalf("Create Wasm instance failed, error:\n%s", err.Error())
+		}
+		result, err := instance.GetExport(store, "f").Func().Call(store, 3)
+		if err != nil {
+			t.Fatalf("Run Wasm instance failed, error:\n%s", err.Error())
+		}
+		if result != int64(5) {
+			t.Errorf("Result error, expected: 5, got %v", result)
+		}
+	}
+}
+
+// TestWasmDuplicateLocal checks that locals which would share an index are
+// rejected rather than silently merged.
+func TestWasmDuplicateLocal(t *testing.T) {
+	m := &cc.WasmModule{
+		Funcs: []*cc.WasmFunc{{
+			Name:   "f",
+			Params: []*cc.WasmLocal{{Name: "x", Type: "i32"}},
+			Locals: []*cc.WasmLocal{{Name: "x", Type: "i32"}},
+			Body:   []*cc.Instr{{Op: "local.get", Name: "x"}, {Op: "drop"}},
+		}},
+	}
+	err := cc.WriteWasm(new(bytes.Buffer), m)
+	if err == nil || err.Error() != "duplicate local 'x'" {
+		t.Errorf("Expected a duplicate local error, got: %v", err)
+	}
+}